@@ -31,6 +31,9 @@ Use cases:
 	rootCmd.AddCommand(cli.NewSyncCmd())
 	rootCmd.AddCommand(cli.NewExportCmd())
 	rootCmd.AddCommand(cli.NewImportCmd())
+	rootCmd.AddCommand(cli.NewCheckCmd())
+	rootCmd.AddCommand(cli.NewKeygenCmd())
+	rootCmd.AddCommand(cli.NewVerifyCmd())
 
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
@@ -0,0 +1,379 @@
+package cli
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Export archive formats. All of them hold the same manifest.yaml,
+// patterns.yaml and files/<id>/... entries.
+const (
+	formatZip   = "zip"
+	formatTarGz = "tar.gz"
+	formatDir   = "dir"
+)
+
+var archiveFormats = []string{formatZip, formatTarGz, formatDir}
+
+// stdioPath reads the archive from stdin or writes it to stdout
+const stdioPath = "-"
+
+// archiveWriter adds entries to an export archive
+type archiveWriter interface {
+	// AddFile copies a file into the archive, keeping its mode and owner
+	// where the format can store them
+	AddFile(name string, info os.FileInfo, r io.Reader) error
+	// AddData writes generated content such as the manifest
+	AddData(name string, data []byte) error
+	Close() error
+}
+
+// detectExportFormat picks a format from the output path: "-" streams a
+// tar.gz, existing directories and paths ending in a slash are written as
+// directories, and everything else is a zip unless it ends in .tar.gz or .tgz
+func detectExportFormat(outputPath string) string {
+	switch {
+	case outputPath == stdioPath:
+		return formatTarGz
+	case strings.HasSuffix(outputPath, ".tar.gz") || strings.HasSuffix(outputPath, ".tgz"):
+		return formatTarGz
+	case strings.HasSuffix(outputPath, "/") || dirExists(outputPath):
+		return formatDir
+	default:
+		return formatZip
+	}
+}
+
+// newArchiveWriter creates a writer for format. Zip and tar.gz archives
+// are written to w; directories are created at dirPath.
+func newArchiveWriter(format string, w io.Writer, dirPath string) (archiveWriter, error) {
+	switch format {
+	case formatZip:
+		return &zipArchiveWriter{zw: zip.NewWriter(w)}, nil
+	case formatTarGz:
+		gz := gzip.NewWriter(w)
+		return &tarArchiveWriter{gz: gz, tw: tar.NewWriter(gz)}, nil
+	case formatDir:
+		return newDirArchiveWriter(dirPath)
+	default:
+		return nil, fmt.Errorf("unknown format: %s (use %s)", format, strings.Join(archiveFormats, ", "))
+	}
+}
+
+type zipArchiveWriter struct {
+	zw *zip.Writer
+}
+
+func (a *zipArchiveWriter) AddFile(name string, info os.FileInfo, r io.Reader) error {
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	header.Method = zip.Deflate
+
+	w, err := a.zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	return err
+}
+
+func (a *zipArchiveWriter) AddData(name string, data []byte) error {
+	w, err := a.zw.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func (a *zipArchiveWriter) Close() error {
+	return a.zw.Close()
+}
+
+type tarArchiveWriter struct {
+	gz *gzip.Writer
+	tw *tar.Writer
+}
+
+func (a *tarArchiveWriter) AddFile(name string, info os.FileInfo, r io.Reader) error {
+	// FileInfoHeader records the owner's uid, gid and names
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	header.Format = tar.FormatPAX
+
+	if err := a.tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err = io.Copy(a.tw, r)
+	return err
+}
+
+func (a *tarArchiveWriter) AddData(name string, data []byte) error {
+	header := &tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+	}
+	if err := a.tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := a.tw.Write(data)
+	return err
+}
+
+func (a *tarArchiveWriter) Close() error {
+	if err := a.tw.Close(); err != nil {
+		return err
+	}
+	return a.gz.Close()
+}
+
+// dirArchiveWriter lays the archive out as plain files, for syncing with
+// tools like rsync
+type dirArchiveWriter struct {
+	root string
+}
+
+func newDirArchiveWriter(root string) (*dirArchiveWriter, error) {
+	if entries, err := os.ReadDir(root); err == nil && len(entries) > 0 {
+		return nil, fmt.Errorf("output directory %s is not empty", root)
+	}
+	if err := os.MkdirAll(root, 0700); err != nil {
+		return nil, err
+	}
+	return &dirArchiveWriter{root: root}, nil
+}
+
+func (a *dirArchiveWriter) create(name string, mode os.FileMode) (*os.File, error) {
+	dest := filepath.Join(a.root, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(dest), 0700); err != nil {
+		return nil, err
+	}
+	return os.OpenFile(dest, os.O_CREATE|os.O_EXCL|os.O_WRONLY, mode)
+}
+
+func (a *dirArchiveWriter) AddFile(name string, info os.FileInfo, r io.Reader) error {
+	f, err := a.create(name, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (a *dirArchiveWriter) AddData(name string, data []byte) error {
+	f, err := a.create(name, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (a *dirArchiveWriter) Close() error {
+	return nil
+}
+
+// archiveEntry is a file in an export archive, whatever its format
+type archiveEntry struct {
+	Name string
+	Mode os.FileMode
+	read func() ([]byte, error)
+}
+
+// Read returns the content of the entry. Zip entries are checked against
+// their CRC-32.
+func (e *archiveEntry) Read() ([]byte, error) {
+	return e.read()
+}
+
+// archive is an opened export archive
+type archive struct {
+	Format  string
+	Entries []*archiveEntry // in archive order
+	byName  map[string]*archiveEntry
+	closer  io.Closer // the file zip entries are read from, if any
+}
+
+func newArchive(format string, entries []*archiveEntry) *archive {
+	a := &archive{Format: format, Entries: entries, byName: make(map[string]*archiveEntry)}
+	for _, e := range entries {
+		a.byName[e.Name] = e
+	}
+	return a
+}
+
+// Lookup returns the entry with the given slash-separated name
+func (a *archive) Lookup(name string) (*archiveEntry, bool) {
+	e, ok := a.byName[name]
+	return e, ok
+}
+
+// Close releases the file the archive is read from
+func (a *archive) Close() error {
+	if a.closer == nil {
+		return nil
+	}
+	return a.closer.Close()
+}
+
+func isZipData(data []byte) bool {
+	return bytes.HasPrefix(data, []byte("PK\x03\x04")) || bytes.HasPrefix(data, []byte("PK\x05\x06"))
+}
+
+func isGzipData(data []byte) bool {
+	return bytes.HasPrefix(data, []byte{0x1f, 0x8b})
+}
+
+// readArchiveData opens a zip or tar.gz archive held in memory, telling
+// them apart by their magic bytes
+func readArchiveData(data []byte) (*archive, error) {
+	switch {
+	case isZipData(data):
+		return readZipArchive(bytes.NewReader(data), int64(len(data)))
+	case isGzipData(data):
+		return readTarGzArchive(bytes.NewReader(data))
+	default:
+		return nil, fmt.Errorf("unrecognized archive format (expected zip, tar.gz or a directory)")
+	}
+}
+
+// readArchiveStream opens a zip or tar.gz archive from r without holding
+// the whole archive in memory. Tar.gz archives are decompressed as they
+// are read. Zip archives need random access, so they are read in place
+// from file, or buffered when r is not a file (stdin).
+func readArchiveStream(r *bufio.Reader, file *os.File) (*archive, error) {
+	head, _ := r.Peek(4)
+	switch {
+	case isZipData(head) && file != nil:
+		info, err := file.Stat()
+		if err != nil {
+			return nil, err
+		}
+		return readZipArchive(file, info.Size())
+	case isGzipData(head):
+		return readTarGzArchive(r)
+	default:
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		return readArchiveData(data)
+	}
+}
+
+func readZipArchive(r io.ReaderAt, size int64) (*archive, error) {
+	reader, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []*archiveEntry
+	for _, file := range reader.File {
+		entries = append(entries, &archiveEntry{
+			Name: file.Name,
+			Mode: file.Mode(),
+			read: func() ([]byte, error) { return readZipFile(file) },
+		})
+	}
+	return newArchive(formatZip, entries), nil
+}
+
+// readZipFile reads the content of a zip entry
+func readZipFile(zipFile *zip.File) ([]byte, error) {
+	rc, err := zipFile.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+// readTarGzArchive reads the content of every entry up front, since a tar
+// stream can only be read in order
+func readTarGzArchive(r io.Reader) (*archive, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	var entries []*archiveEntry
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var content []byte
+		if header.Typeflag == tar.TypeReg {
+			if content, err = io.ReadAll(tr); err != nil {
+				return nil, err
+			}
+		}
+		entries = append(entries, &archiveEntry{
+			Name: header.Name, // not cleaned, so traversal attempts are rejected and reported
+			Mode: header.FileInfo().Mode(),
+			read: func() ([]byte, error) { return content, nil },
+		})
+	}
+	return newArchive(formatTarGz, entries), nil
+}
+
+// readDirArchive lists an archive exported as a directory. Symlinks are
+// listed but not followed, so they are rejected as non-regular files.
+func readDirArchive(root string) (*archive, error) {
+	var entries []*archiveEntry
+	err := filepath.WalkDir(root, func(p string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, &archiveEntry{
+			Name: filepath.ToSlash(rel),
+			Mode: info.Mode(),
+			read: func() ([]byte, error) { return os.ReadFile(p) },
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newArchive(formatDir, entries), nil
+}
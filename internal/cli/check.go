@@ -0,0 +1,307 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/O6lvl4/igloc/internal/scanner"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// defaultBaselineFile is looked up in the scanned directory
+const defaultBaselineFile = ".igloc-baseline.yaml"
+
+// Baseline lists accepted findings that check should not fail on
+type Baseline struct {
+	Version   int             `yaml:"version"`
+	UpdatedAt time.Time       `yaml:"updated_at"`
+	Findings  []BaselineEntry `yaml:"findings"`
+}
+
+// BaselineEntry identifies one accepted finding
+type BaselineEntry struct {
+	Path        string `yaml:"path"` // relative to the scanned directory
+	Fingerprint string `yaml:"fingerprint"`
+}
+
+// checkFinding is a secret file that meets the check thresholds
+type checkFinding struct {
+	Path        string
+	Category    string
+	Severity    string
+	Fingerprint string
+}
+
+// severityRank orders severities, matching SARIF levels
+var severityRank = map[string]int{
+	"note":    1,
+	"warning": 2,
+	"error":   3,
+}
+
+var (
+	checkRecursive      bool
+	checkIncludeDeps    bool
+	checkNoContent      bool
+	checkFailOn         string
+	checkCategories     []string
+	checkBaseline       string
+	checkUpdateBaseline bool
+	checkBackend        string
+	checkJobs           int
+)
+
+// NewCheckCmd creates the check command
+func NewCheckCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "check [path]",
+		Short: "Fail when new secrets are found (for CI)",
+		Long: `Scan for secrets and exit with a non-zero status when any finding at or
+above the --fail-on severity is not listed in the baseline file.
+
+Severities follow the SARIF output: key files and known credential formats
+are errors, env files and high-entropy values are warnings, other secret
+files are notes.
+
+The baseline (default: .igloc-baseline.yaml in the scanned directory)
+lists accepted findings by path and fingerprint. A fingerprint covers the
+category and the kinds of credentials found, so a new kind of secret in an
+accepted file is reported again.
+
+With -r, a repository that cannot be scanned also fails the check.
+
+Examples:
+  igloc check                          # Fail on new warnings or errors
+  igloc check --fail-on error          # Fail only on errors
+  igloc check -r ~/projects -c env,key # Only env and key files
+  igloc check --update-baseline        # Accept all current findings`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: runCheck,
+	}
+
+	cmd.Flags().BoolVarP(&checkRecursive, "recursive", "r", false, "Recursively scan subdirectories for git repos")
+	cmd.Flags().BoolVar(&checkIncludeDeps, "include-deps", false, "Include files in node_modules, vendor, etc.")
+	cmd.Flags().BoolVar(&checkNoContent, "no-content", false, "Don't inspect file contents for credentials")
+	cmd.Flags().StringVar(&checkFailOn, "fail-on", "warning", "Minimum severity that fails the check (note, warning, error)")
+	cmd.Flags().StringSliceVarP(&checkCategories, "category", "c", nil, "Only check these categories")
+	cmd.Flags().StringVar(&checkBaseline, "baseline", "", "Baseline file (default: <path>/"+defaultBaselineFile+")")
+	cmd.Flags().BoolVar(&checkUpdateBaseline, "update-baseline", false, "Write all current findings to the baseline and exit")
+	cmd.Flags().StringVar(&checkBackend, "backend", "auto", "How to find ignored files (auto, git, native)")
+	cmd.Flags().IntVarP(&checkJobs, "jobs", "j", scanner.DefaultJobs(), "Number of repositories to scan in parallel with -r")
+
+	return cmd
+}
+
+func runCheck(cmd *cobra.Command, args []string) error {
+	path := "."
+	if len(args) > 0 {
+		path = args[0]
+	}
+
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("invalid path: %w", err)
+	}
+
+	if _, err := os.Stat(absPath); os.IsNotExist(err) {
+		return fmt.Errorf("path does not exist: %s", absPath)
+	}
+
+	minRank, ok := severityRank[checkFailOn]
+	if !ok {
+		return fmt.Errorf("invalid --fail-on value: %s (use note, warning or error)", checkFailOn)
+	}
+
+	s, err := newScanner()
+	if err != nil {
+		return err
+	}
+	s.ExcludeDeps = !checkIncludeDeps
+	s.InspectContent = !checkNoContent
+	if s.Backend, err = scanner.ParseBackend(checkBackend); err != nil {
+		return err
+	}
+
+	for _, cat := range checkCategories {
+		if !s.Rules.HasCategory(cat) {
+			return fmt.Errorf("unknown category: %s (available: %s)",
+				cat, strings.Join(s.Rules.Categories(), ", "))
+		}
+	}
+	s.Categories = checkCategories
+
+	var results []*scanner.ScanResult
+	failed := 0
+	if checkRecursive {
+		err = s.ScanRecursive(absPath, checkJobs, func(result *scanner.ScanResult, err error) {
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: could not scan %v\n", err)
+				failed++
+				return
+			}
+			results = append(results, result)
+		})
+	} else {
+		var result *scanner.ScanResult
+		result, err = s.Scan(absPath)
+		results = append(results, result)
+	}
+	if err != nil {
+		return fmt.Errorf("scan failed: %w", err)
+	}
+
+	// Findings and unscanned repositories are not usage errors; main
+	// reports the error once
+	cmd.SilenceUsage = true
+	cmd.SilenceErrors = true
+
+	baselinePath := checkBaseline
+	if baselinePath == "" {
+		baselinePath = filepath.Join(absPath, defaultBaselineFile)
+	}
+
+	if checkUpdateBaseline {
+		if failed > 0 {
+			return fmt.Errorf("%d repositories could not be scanned; baseline not written", failed)
+		}
+		// Accept every finding, so a later run with a lower --fail-on
+		// doesn't report the ones below today's threshold as new
+		findings := collectCheckFindings(absPath, results, severityRank["note"])
+		if err := saveBaseline(baselinePath, findings); err != nil {
+			return fmt.Errorf("failed to write baseline: %w", err)
+		}
+		fmt.Printf("Wrote %d findings to %s\n", len(findings), baselinePath)
+		return nil
+	}
+
+	findings := collectCheckFindings(absPath, results, minRank)
+
+	baseline, err := loadBaseline(baselinePath)
+	if err != nil {
+		return fmt.Errorf("failed to read baseline: %w", err)
+	}
+
+	accepted := make(map[BaselineEntry]bool)
+	for _, entry := range baseline.Findings {
+		accepted[entry] = true
+	}
+
+	var newFindings []checkFinding
+	for _, f := range findings {
+		if !accepted[BaselineEntry{Path: f.Path, Fingerprint: f.Fingerprint}] {
+			newFindings = append(newFindings, f)
+		}
+	}
+
+	for _, f := range newFindings {
+		fmt.Printf("✗ %s [%s, %s] %s\n", f.Path, f.Category, f.Severity, f.Fingerprint)
+	}
+
+	fmt.Printf("\n%d findings, %d in baseline, %d new\n",
+		len(findings), len(findings)-len(newFindings), len(newFindings))
+
+	if len(newFindings) > 0 {
+		return fmt.Errorf("%d new secret findings at or above %s", len(newFindings), checkFailOn)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d repositories could not be scanned", failed)
+	}
+
+	return nil
+}
+
+// collectCheckFindings returns the secret files that meet the severity
+// threshold, with paths relative to rootPath. Categories are filtered by
+// the scanner.
+func collectCheckFindings(rootPath string, results []*scanner.ScanResult, minRank int) []checkFinding {
+	var findings []checkFinding
+
+	for _, result := range results {
+		for _, f := range result.IgnoredFiles {
+			if !f.IsSecret {
+				continue
+			}
+
+			severity := fileSeverity(f)
+			if severityRank[severity] < minRank {
+				continue
+			}
+
+			path := filepath.Join(result.RootPath, f.Path)
+			if rel, err := filepath.Rel(rootPath, path); err == nil {
+				path = rel
+			}
+
+			findings = append(findings, checkFinding{
+				Path:        filepath.ToSlash(path),
+				Category:    f.Category,
+				Severity:    severity,
+				Fingerprint: f.Fingerprint(),
+			})
+		}
+	}
+
+	sort.Slice(findings, func(i, j int) bool { return findings[i].Path < findings[j].Path })
+	return findings
+}
+
+// fileSeverity is the highest level among a file's category and findings
+func fileSeverity(f scanner.IgnoredFile) string {
+	severity := categoryLevel(f.Category)
+	for _, finding := range f.Findings {
+		if level := contentLevel(finding.RuleID); severityRank[level] > severityRank[severity] {
+			severity = level
+		}
+	}
+	return severity
+}
+
+func loadBaseline(path string) (*Baseline, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &Baseline{}, nil // no baseline, every finding is new
+		}
+		return nil, err
+	}
+
+	var baseline Baseline
+	if err := yaml.Unmarshal(data, &baseline); err != nil {
+		return nil, err
+	}
+	return &baseline, nil
+}
+
+func saveBaseline(path string, findings []checkFinding) error {
+	baseline := Baseline{
+		Version:   1,
+		UpdatedAt: time.Now(),
+		Findings:  []BaselineEntry{},
+	}
+	for _, f := range findings {
+		baseline.Findings = append(baseline.Findings, BaselineEntry{
+			Path:        f.Path,
+			Fingerprint: f.Fingerprint,
+		})
+	}
+
+	data, err := yaml.Marshal(baseline)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
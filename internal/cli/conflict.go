@@ -0,0 +1,211 @@
+package cli
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Strategies for files that already exist at the import destination
+const (
+	conflictSkip      = "skip"
+	conflictOverwrite = "overwrite"
+	conflictBackup    = "backup"
+	conflictRename    = "rename"
+	conflictPrompt    = "prompt"
+)
+
+var conflictStrategies = []string{conflictSkip, conflictOverwrite, conflictBackup, conflictRename, conflictPrompt}
+
+// maxDiffLines limits the diff preview shown by the prompt strategy
+const maxDiffLines = 20
+
+// maxDiffCells bounds the size of the LCS table for the preview
+const maxDiffCells = 4_000_000
+
+// importSummary records what happened to each imported file
+type importSummary struct {
+	Created   []string
+	Replaced  []string
+	BackedUp  []string // "dest (backup: path)"
+	Renamed   []string // "dest -> new path"
+	Merged    []string // "dest (key counts)"
+	Skipped   []string
+	Unchanged []string
+	Failed    []string
+}
+
+func (s *importSummary) print() {
+	fmt.Println("\nSummary:")
+	fmt.Printf("  Created:   %d\n", len(s.Created))
+	printSummaryList("Replaced", s.Replaced)
+	printSummaryList("Backed up", s.BackedUp)
+	printSummaryList("Renamed", s.Renamed)
+	printSummaryList("Merged", s.Merged)
+	printSummaryList("Skipped", s.Skipped)
+	fmt.Printf("  Unchanged: %d (identical content)\n", len(s.Unchanged))
+	printSummaryList("Failed", s.Failed)
+}
+
+func printSummaryList(label string, paths []string) {
+	if len(paths) == 0 {
+		return
+	}
+	fmt.Printf("  %-10s %d\n", label+":", len(paths))
+	for _, p := range paths {
+		fmt.Printf("    %s\n", p)
+	}
+}
+
+// backupSuffix is shared by all backups of one import run
+var backupSuffix = ".igloc-backup-" + time.Now().Format("20060102-150405")
+
+// uniquePath returns path, or path with a numeric suffix if it is taken
+func uniquePath(path string) string {
+	if !fileExists(path) {
+		return path
+	}
+	for i := 1; ; i++ {
+		candidate := fmt.Sprintf("%s.%d", path, i)
+		if !fileExists(candidate) {
+			return candidate
+		}
+	}
+}
+
+// promptConflict shows a diff of an existing file and the archived version
+// and asks what to do with it
+func promptConflict(destPath string, existing, incoming []byte) string {
+	fmt.Printf("\n  %s already exists with different content:\n", destPath)
+	printDiff(existing, incoming)
+
+	for {
+		fmt.Print("  [o]verwrite, [s]kip, [b]ackup and overwrite, [r]ename new file? ")
+		response, err := stdinReader.ReadString('\n')
+		if err != nil && response == "" {
+			return conflictSkip // no input, leave the file alone
+		}
+		switch strings.TrimSpace(strings.ToLower(response)) {
+		case "o", "overwrite":
+			return conflictOverwrite
+		case "s", "skip", "":
+			return conflictSkip
+		case "b", "backup":
+			return conflictBackup
+		case "r", "rename":
+			return conflictRename
+		}
+	}
+}
+
+// printDiff prints the changed lines between two versions of a file
+func printDiff(oldData, newData []byte) {
+	if isBinaryData(oldData) || isBinaryData(newData) {
+		fmt.Println("    (binary content differs)")
+		return
+	}
+
+	oldLines, newLines := splitLines(oldData), splitLines(newData)
+	if len(oldLines)*len(newLines) > maxDiffCells {
+		fmt.Println("    (files too large to preview)")
+		return
+	}
+
+	lines := diffLines(oldLines, newLines)
+	for i, line := range lines {
+		if i == maxDiffLines {
+			fmt.Printf("    ... %d more changed lines\n", len(lines)-maxDiffLines)
+			break
+		}
+		fmt.Printf("    %s\n", line)
+	}
+}
+
+// diffLines returns the removed ("- ") and added ("+ ") lines between a and
+// b, using a longest common subsequence
+func diffLines(a, b []string) []string {
+	// lcs[i][j] is the LCS length of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var out []string
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			i++
+			j++
+		case j < len(b) && (i == len(a) || lcs[i][j+1] >= lcs[i+1][j]):
+			out = append(out, "+ "+b[j])
+			j++
+		default:
+			out = append(out, "- "+a[i])
+			i++
+		}
+	}
+	return out
+}
+
+func splitLines(data []byte) []string {
+	s := strings.TrimSuffix(string(data), "\n")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
+func isBinaryData(data []byte) bool {
+	if len(data) > 8000 {
+		data = data[:8000]
+	}
+	return bytes.IndexByte(data, 0) >= 0
+}
+
+// writeConflictFile applies a strategy to an existing destination and
+// calls write with the path to write the incoming file to. It returns that
+// path, or "" if skipped.
+func writeConflictFile(tx *importTx, destPath, strategy string, write func(path string) error, summary *importSummary) (string, error) {
+	switch strategy {
+	case conflictSkip:
+		summary.Skipped = append(summary.Skipped, destPath)
+		return "", nil
+
+	case conflictBackup:
+		backupPath := uniquePath(destPath + backupSuffix)
+		if err := tx.Rename(destPath, backupPath); err != nil {
+			return "", fmt.Errorf("backup failed: %w", err)
+		}
+		if err := write(destPath); err != nil {
+			return "", err
+		}
+		summary.BackedUp = append(summary.BackedUp, fmt.Sprintf("%s (backup: %s)", destPath, backupPath))
+		return destPath, nil
+
+	case conflictRename:
+		newPath := uniquePath(destPath + ".imported")
+		if err := write(newPath); err != nil {
+			return "", err
+		}
+		summary.Renamed = append(summary.Renamed, fmt.Sprintf("%s -> %s", destPath, newPath))
+		return newPath, nil
+
+	default:
+		if err := write(destPath); err != nil {
+			return "", err
+		}
+		summary.Replaced = append(summary.Replaced, destPath)
+		return destPath, nil
+	}
+}
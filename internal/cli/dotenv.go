@@ -0,0 +1,238 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/O6lvl4/igloc/internal/scanner"
+)
+
+// dotenvKeyPattern matches "KEY=value" and "export KEY=value" lines
+var dotenvKeyPattern = regexp.MustCompile(`^\s*(?:export\s+)?([A-Za-z_][A-Za-z0-9_.-]*)\s*=\s*(.*)$`)
+
+// dotenvEntry is one assignment, which may span several lines when the
+// value is a multi-line quoted string
+type dotenvEntry struct {
+	Key   string
+	Value string // raw value, quotes included
+	Start int    // first line index
+	End   int    // one past the last line index
+}
+
+// dotenvFile keeps the original lines so a merge only touches what changed
+type dotenvFile struct {
+	Lines   []string
+	Entries []dotenvEntry
+	index   map[string]int // key -> last entry with that key
+}
+
+func parseDotenv(data []byte) *dotenvFile {
+	f := &dotenvFile{
+		Lines: splitLines(data),
+		index: make(map[string]int),
+	}
+
+	for i := 0; i < len(f.Lines); i++ {
+		m := dotenvKeyPattern.FindStringSubmatch(f.Lines[i])
+		if m == nil {
+			continue // blank, comment or unparsable line
+		}
+
+		entry := dotenvEntry{Key: m[1], Value: m[2], Start: i, End: i + 1}
+
+		// A quoted value without its closing quote continues on later lines
+		if q := m[2]; len(q) > 0 && (q[0] == '"' || q[0] == '\'') && !closesQuote(q[1:], q[0]) {
+			for j := i + 1; j < len(f.Lines); j++ {
+				entry.Value += "\n" + f.Lines[j]
+				entry.End = j + 1
+				if closesQuote(f.Lines[j], q[0]) {
+					break
+				}
+			}
+			i = entry.End - 1
+		}
+
+		f.index[entry.Key] = len(f.Entries)
+		f.Entries = append(f.Entries, entry)
+	}
+
+	return f
+}
+
+// closesQuote reports whether s contains an unescaped quote character
+func closesQuote(s string, quote byte) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && quote == '"' {
+			i++
+			continue
+		}
+		if s[i] == quote {
+			return true
+		}
+	}
+	return false
+}
+
+// lookup returns the effective value of a key; later assignments win
+func (f *dotenvFile) lookup(key string) (dotenvEntry, bool) {
+	i, ok := f.index[key]
+	if !ok {
+		return dotenvEntry{}, false
+	}
+	return f.Entries[i], true
+}
+
+// dotenvMerge is the result of merging an archived env file into a local one
+type dotenvMerge struct {
+	Data      []byte
+	Added     []string // keys only in the archive
+	Replaced  []string // conflicting keys that took the archive's value
+	Kept      []string // conflicting keys that kept the local value
+	Unchanged bool
+}
+
+// mergeDotenv adds keys missing from local and asks resolve for each key
+// whose values differ. resolve returns true to take the archive's value.
+// Lines, comments and key order of the local file are preserved.
+func mergeDotenv(local, archived []byte, resolve func(key, localValue, archivedValue string) bool) *dotenvMerge {
+	lf := parseDotenv(local)
+	af := parseDotenv(archived)
+	result := &dotenvMerge{}
+
+	replace := make(map[int]string) // local entry index -> new value
+	var added []dotenvEntry
+	seen := make(map[string]bool)
+
+	for _, entry := range af.Entries {
+		if seen[entry.Key] {
+			continue
+		}
+		seen[entry.Key] = true
+		entry, _ = af.lookup(entry.Key)
+
+		current, ok := lf.lookup(entry.Key)
+		switch {
+		case !ok:
+			added = append(added, entry)
+			result.Added = append(result.Added, entry.Key)
+		case current.Value == entry.Value:
+			// same value on both sides
+		case resolve(entry.Key, current.Value, entry.Value):
+			replace[lf.index[entry.Key]] = entry.Value
+			result.Replaced = append(result.Replaced, entry.Key)
+		default:
+			result.Kept = append(result.Kept, entry.Key)
+		}
+	}
+
+	if len(added) == 0 && len(replace) == 0 {
+		result.Data = local
+		result.Unchanged = true
+		return result
+	}
+
+	var out []string
+	next := 0
+	for i, entry := range lf.Entries {
+		out = append(out, lf.Lines[next:entry.Start]...)
+		if value, ok := replace[i]; ok {
+			prefix := lf.Lines[entry.Start][:strings.Index(lf.Lines[entry.Start], "=")+1]
+			out = append(out, strings.Split(prefix+value, "\n")...)
+		} else {
+			out = append(out, lf.Lines[entry.Start:entry.End]...)
+		}
+		next = entry.End
+	}
+	out = append(out, lf.Lines[next:]...)
+
+	if len(added) > 0 {
+		if len(out) > 0 && out[len(out)-1] != "" {
+			out = append(out, "")
+		}
+		out = append(out, fmt.Sprintf("# Added by igloc import on %s", time.Now().Format("2006-01-02")))
+		for _, entry := range added {
+			out = append(out, af.Lines[entry.Start:entry.End]...)
+		}
+	}
+
+	result.Data = []byte(strings.Join(out, "\n") + "\n")
+	return result
+}
+
+// promptDotenvKey asks whether to take the archive's value for a key
+func promptDotenvKey(path string) func(key, localValue, archivedValue string) bool {
+	return func(key, localValue, archivedValue string) bool {
+		fmt.Printf("\n  %s: %s differs\n", path, key)
+		fmt.Printf("    local:   %s\n", localValue)
+		fmt.Printf("    archive: %s\n", archivedValue)
+		for {
+			fmt.Print("  Keep [l]ocal or use [a]rchive value? ")
+			response, err := stdinReader.ReadString('\n')
+			if err != nil && response == "" {
+				return false
+			}
+			switch strings.TrimSpace(strings.ToLower(response)) {
+			case "l", "local", "":
+				return false
+			case "a", "archive":
+				return true
+			}
+		}
+	}
+}
+
+// isDotenvFile reports whether rules classify path as an env file. A nil
+// ruleset disables merging.
+func isDotenvFile(rules *scanner.Ruleset, path string) bool {
+	if rules == nil {
+		return false
+	}
+	category, _ := rules.Classify(path)
+	return category == "env"
+}
+
+// mergeEnvFile merges an archived env file into the existing one. Key
+// conflicts follow the import strategy.
+func mergeEnvFile(tx *importTx, destPath string, existing, archived []byte, strategy string, summary *importSummary) error {
+	var resolve func(key, localValue, archivedValue string) bool
+	switch strategy {
+	case conflictPrompt:
+		resolve = promptDotenvKey(destPath)
+	case conflictOverwrite, conflictBackup:
+		resolve = func(string, string, string) bool { return true }
+	default:
+		resolve = func(string, string, string) bool { return false }
+	}
+
+	merge := mergeDotenv(existing, archived, resolve)
+	detail := fmt.Sprintf("%d added, %d replaced, %d kept local", len(merge.Added), len(merge.Replaced), len(merge.Kept))
+	if merge.Unchanged {
+		summary.Unchanged = append(summary.Unchanged, destPath)
+		fmt.Printf("  = %s (no new keys, %d kept local)\n", destPath, len(merge.Kept))
+		return nil
+	}
+
+	info, err := os.Stat(destPath)
+	if err != nil {
+		return err
+	}
+
+	if strategy == conflictBackup {
+		backupPath := uniquePath(destPath + backupSuffix)
+		if err := tx.WriteFile(backupPath, existing, info.Mode().Perm(), time.Time{}); err != nil {
+			return fmt.Errorf("backup failed: %w", err)
+		}
+		detail += ", backup: " + backupPath
+	}
+
+	if err := tx.WriteFile(destPath, merge.Data, info.Mode().Perm(), time.Time{}); err != nil {
+		return err
+	}
+
+	summary.Merged = append(summary.Merged, fmt.Sprintf("%s (%s)", destPath, detail))
+	fmt.Printf("  ~ %s (%s)\n", destPath, detail)
+	return nil
+}
@@ -1,53 +1,145 @@
 package cli
 
 import (
-	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/O6lvl4/igloc/internal/config"
+	"github.com/O6lvl4/igloc/internal/encrypt"
 	"github.com/O6lvl4/igloc/internal/scanner"
-	"gopkg.in/yaml.v3"
 	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
 )
 
+// manifestVersion is the manifest format written by export. Version 1
+// archives stored files under the repository name instead of its ID.
+const manifestVersion = 2
+
 // Manifest describes the contents of an export archive
 type Manifest struct {
-	Version   int           `yaml:"version"`
-	CreatedAt time.Time     `yaml:"created_at"`
-	Machine   string        `yaml:"machine,omitempty"`
-	Repos     []RepoExport  `yaml:"repos"`
+	Version   int          `yaml:"version"`
+	CreatedAt time.Time    `yaml:"created_at"`
+	Machine   string       `yaml:"machine,omitempty"`
+	Repos     []RepoExport `yaml:"repos"`
 }
 
 // RepoExport describes exported files from a repository
 type RepoExport struct {
-	Name   string   `yaml:"name"`
-	Path   string   `yaml:"path"`
-	Files  []string `yaml:"files"`
+	ID         string   `yaml:"id"`                  // unique within the archive, names files/<id>/
+	Name       string   `yaml:"name"`                // display name
+	Path       string   `yaml:"path"`                // absolute path on the exporting machine
+	RelPath    string   `yaml:"rel_path,omitempty"`  // relative to the export scan root
+	HomePath   string   `yaml:"home_path,omitempty"` // relative to $HOME, if below it
+	Remotes    []string `yaml:"remotes,omitempty"`
+	RootCommit string   `yaml:"root_commit,omitempty"`
+	Files      []string `yaml:"files"`
+
+	// Checksums maps each file to its digest so import and verify can
+	// detect corrupted archives. Older archives have none.
+	Checksums map[string]FileChecksum `yaml:"checksums,omitempty"`
+
+	// Attrs records the mode, modification time and, for symlinks, the
+	// target of each file so import can restore them. Older archives have
+	// none.
+	Attrs map[string]FileAttrs `yaml:"attrs,omitempty"`
+}
+
+// FileChecksum is the SHA-256 digest and size of an exported file
+type FileChecksum struct {
+	SHA256 string `yaml:"sha256"`
+	Size   int64  `yaml:"size"`
+}
+
+// FileAttrs are the file system attributes of an exported file
+type FileAttrs struct {
+	Mode    string    `yaml:"mode"` // permission bits in octal, e.g. "0600"
+	ModTime time.Time `yaml:"mtime"`
+	Link    string    `yaml:"link,omitempty"` // symlink target; links have no content in the archive
+}
+
+// perm returns the recorded permission bits
+func (a FileAttrs) perm() (os.FileMode, bool) {
+	mode, err := strconv.ParseUint(a.Mode, 8, 32)
+	if err != nil {
+		return 0, false
+	}
+	return os.FileMode(mode).Perm(), true
+}
+
+// fileAttrs records the attributes of a file from its Lstat info
+func fileAttrs(info os.FileInfo) FileAttrs {
+	return FileAttrs{
+		Mode:    fmt.Sprintf("%04o", info.Mode().Perm()),
+		ModTime: info.ModTime().UTC().Truncate(time.Second),
+	}
+}
+
+// identity returns what identifies the repository regardless of its path
+func (r RepoExport) identity() scanner.RepoIdentity {
+	return scanner.RepoIdentity{Remotes: r.Remotes, RootCommit: r.RootCommit}
 }
 
 var (
 	exportRecursive   bool
 	exportIncludeDeps bool
+	exportBackend     string
+	exportJobs        int
+	exportEncrypt     bool
+	exportRecipients  []string
+	exportCategories  []string
+	exportInclude     []string
+	exportExclude     []string
+	exportRepos       []string
+	exportMaxSize     string
+	exportFormat      string
 )
 
 // NewExportCmd creates the export command
 func NewExportCmd() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "export [output.zip]",
-		Short: "Export ignored files to a zip archive",
-		Long: `Export gitignored files (secrets, configs) to a zip archive.
+		Use:   "export [output]",
+		Short: "Export ignored files to an archive",
+		Long: `Export gitignored files (secrets, configs) to an archive.
 
 This creates a portable backup of all your secret files that can be
 imported on another machine.
 
+The format follows the output name: .tar.gz or .tgz writes a gzipped tar,
+which keeps file owners; an existing directory or a name ending in "/"
+writes plain files; anything else writes a zip. "-" writes a tar.gz to
+stdout for piping into ssh or gpg. --format overrides the guess.
+
 Examples:
   igloc export backup.zip                    # Export current repo
   igloc export -r ~/projects secrets.zip     # Export all repos recursively
-  igloc export --path ~/myapp backup.zip     # Export specific directory`,
+  igloc export --path ~/myapp backup.zip     # Export specific directory
+  igloc export --encrypt backup.zip          # Encrypt with a passphrase
+  igloc export --recipient iglocpub1... backup.zip  # Encrypt for a key
+  igloc export -c env,config backup.zip      # Only env and config files
+  igloc export --exclude '*.backup' --max-size 1MB backup.zip
+  igloc export -r --path ~/work --repo 'api*' backup.zip
+  igloc export backup.tar.gz                 # Export as tar.gz
+  igloc export - | ssh host igloc import -y -   # Copy to another machine
+
+By default only files that likely contain secrets are exported. With
+--category or --include, every ignored file in those categories or
+matching those globs is exported, secret or not. Globs without a slash
+match the file name, others the path within the repository.
+
+With --encrypt, the archive is encrypted with AES-256-GCM under a key
+derived from a passphrase (prompted, or read from IGLOC_PASSPHRASE).
+With --recipient, it can be decrypted with the matching private key
+created by igloc keygen. Both can be combined.`,
 		Args: cobra.ExactArgs(1),
 		RunE: runExport,
 	}
@@ -55,6 +147,16 @@ Examples:
 	cmd.Flags().BoolVarP(&exportRecursive, "recursive", "r", false, "Recursively scan subdirectories for git repos")
 	cmd.Flags().String("path", ".", "Path to scan")
 	cmd.Flags().BoolVar(&exportIncludeDeps, "include-deps", false, "Include files in node_modules, vendor, etc.")
+	cmd.Flags().IntVarP(&exportJobs, "jobs", "j", scanner.DefaultJobs(), "Number of repositories to scan in parallel with -r")
+	cmd.Flags().StringVar(&exportBackend, "backend", "auto", "How to find ignored files (auto, git, native)")
+	cmd.Flags().BoolVar(&exportEncrypt, "encrypt", false, "Encrypt the archive with a passphrase")
+	cmd.Flags().StringArrayVar(&exportRecipients, "recipient", nil, "Encrypt the archive for this public key (repeatable)")
+	cmd.Flags().StringSliceVarP(&exportCategories, "category", "c", nil, "Only export these categories")
+	cmd.Flags().StringSliceVar(&exportInclude, "include", nil, "Only export files matching these globs")
+	cmd.Flags().StringSliceVar(&exportExclude, "exclude", nil, "Don't export files matching these globs")
+	cmd.Flags().StringSliceVar(&exportRepos, "repo", nil, "With -r, only export repositories whose name or path matches these globs")
+	cmd.Flags().StringVar(&exportMaxSize, "max-size", "", "Skip files larger than this (e.g. 512K, 10MB)")
+	cmd.Flags().StringVar(&exportFormat, "format", "", "Archive format: "+strings.Join(archiveFormats, ", ")+" (default: from the output name)")
 
 	return cmd
 }
@@ -68,13 +170,73 @@ func runExport(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("invalid path: %w", err)
 	}
 
-	fmt.Printf("Scanning %s...\n", absPath)
+	format := exportFormat
+	if format == "" {
+		format = detectExportFormat(outputPath)
+	}
+	if !containsString(archiveFormats, format) {
+		return fmt.Errorf("invalid --format value: %s (use %s)", format, strings.Join(archiveFormats, ", "))
+	}
+	if format == formatDir && outputPath == stdioPath {
+		return fmt.Errorf("cannot write a directory to stdout")
+	}
 
-	// Collect files to export
-	var repos []RepoExport
-	s := scanner.NewScanner()
+	// Progress goes to stderr when the archive itself goes to stdout
+	out := io.Writer(os.Stdout)
+	if outputPath == stdioPath {
+		out = os.Stderr
+	}
+
+	s, err := newScanner()
+	if err != nil {
+		return err
+	}
 	s.ExcludeDeps = !exportIncludeDeps
+	if s.Backend, err = scanner.ParseBackend(exportBackend); err != nil {
+		return err
+	}
 
+	// Filters are applied by the scanner, before contents are inspected
+	for _, cat := range exportCategories {
+		if !s.Rules.HasCategory(cat) {
+			return fmt.Errorf("unknown category: %s (available: %s)",
+				cat, strings.Join(s.Rules.Categories(), ", "))
+		}
+	}
+	s.Categories = exportCategories
+	s.Include = exportInclude
+	s.Exclude = exportExclude
+	s.Repos = exportRepos
+	s.ShowAll = len(exportCategories) > 0 || len(exportInclude) > 0
+	if exportMaxSize != "" {
+		if s.MaxSize, err = parseSize(exportMaxSize); err != nil {
+			return fmt.Errorf("invalid --max-size: %w", err)
+		}
+	}
+
+	encrypted := exportEncrypt || len(exportRecipients) > 0
+	if encrypted && format == formatDir {
+		return fmt.Errorf("directory exports cannot be encrypted; use zip or tar.gz")
+	}
+
+	// Resolve encryption keys before scanning so a bad key fails fast
+	var encOpts encrypt.Options
+	for _, r := range exportRecipients {
+		key, err := encrypt.ParsePublicKey(r)
+		if err != nil {
+			return err
+		}
+		encOpts.Recipients = append(encOpts.Recipients, key)
+	}
+	if exportEncrypt {
+		if encOpts.Passphrase, err = readPassphrase(true); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(out, "Scanning %s...\n", absPath)
+
+	// Collect files to export
+	var repos []RepoExport
 	if exportRecursive {
 		repos, err = collectReposRecursive(s, absPath)
 	} else {
@@ -85,7 +247,7 @@ func runExport(cmd *cobra.Command, args []string) error {
 	}
 
 	if len(repos) == 0 {
-		fmt.Println("No files to export.")
+		fmt.Fprintln(out, "No files to export.")
 		return nil
 	}
 
@@ -95,98 +257,251 @@ func runExport(cmd *cobra.Command, args []string) error {
 		totalFiles += len(repo.Files)
 	}
 
-	fmt.Printf("Found %d files in %d repositories\n", totalFiles, len(repos))
+	fmt.Fprintf(out, "Found %d files in %d repositories\n", totalFiles, len(repos))
+
+	assignRepoIDs(absPath, repos)
+	setPortablePaths(absPath, repos)
 
-	// Create zip file
-	if err := createExportZip(outputPath, repos); err != nil {
-		return fmt.Errorf("failed to create zip: %w", err)
+	if format == formatDir {
+		aw, err := newArchiveWriter(format, nil, outputPath)
+		if err != nil {
+			return err
+		}
+		if err := writeExportArchive(aw, repos, out); err != nil {
+			return fmt.Errorf("failed to write archive: %w", err)
+		}
+		fmt.Fprintf(out, "\nExported to %s\n", outputPath)
+		return nil
 	}
 
-	// Get file size
-	info, _ := os.Stat(outputPath)
-	fmt.Printf("\nExported to %s (%s)\n", outputPath, formatSize(info.Size()))
+	size, err := writeArchiveOutput(format, outputPath, repos, encrypted, encOpts, out)
+	if err != nil {
+		return err
+	}
+
+	name, suffix := outputPath, ""
+	if outputPath == stdioPath {
+		name = "stdout"
+	}
+	if encrypted {
+		suffix = ", encrypted"
+	}
+	fmt.Fprintf(out, "\nExported to %s (%s, %s%s)\n", name, format, formatSize(size), suffix)
 
 	return nil
 }
 
+// writeArchiveOutput writes a zip or tar.gz archive to outputPath or
+// stdout and returns its size. Plain archives are streamed; only encrypted
+// ones are built in memory, since they are sealed as a whole.
+func writeArchiveOutput(format, outputPath string, repos []RepoExport, encrypted bool, encOpts encrypt.Options, out io.Writer) (int64, error) {
+	var buf bytes.Buffer
+	dest := io.Writer(&buf)
+	var file *os.File
+	switch {
+	case encrypted:
+	case outputPath == stdioPath:
+		dest = os.Stdout
+	default:
+		f, err := os.OpenFile(outputPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			return 0, fmt.Errorf("failed to write archive: %w", err)
+		}
+		dest, file = f, f
+	}
+
+	cw := &countingWriter{w: dest}
+	aw, err := newArchiveWriter(format, cw, "")
+	if err == nil {
+		err = writeExportArchive(aw, repos, out)
+	}
+	if file != nil {
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(outputPath)
+		}
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to create archive: %w", err)
+	}
+	if !encrypted {
+		return cw.n, nil
+	}
+
+	data, err := encrypt.Seal(buf.Bytes(), encOpts)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encrypt archive: %w", err)
+	}
+	if outputPath == stdioPath {
+		_, err = os.Stdout.Write(data)
+	} else {
+		err = os.WriteFile(outputPath, data, 0600)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to write archive: %w", err)
+	}
+	return int64(len(data)), nil
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
 func collectSingleRepo(s *scanner.Scanner, path string) ([]RepoExport, error) {
 	result, err := s.Scan(path)
 	if err != nil {
 		return nil, err
 	}
 
-	if len(result.IgnoredFiles) == 0 {
-		return nil, nil
-	}
+	return repoExportFromResult(s, result), nil
+}
 
+// repoExportFromResult lists the files of a scanned repository, already
+// filtered by the scanner, and records its remotes and root commit so
+// import can find other clones
+func repoExportFromResult(s *scanner.Scanner, result *scanner.ScanResult) []RepoExport {
 	var files []string
 	for _, f := range result.IgnoredFiles {
-		if f.IsSecret {
-			files = append(files, f.Path)
-		}
+		files = append(files, f.Path)
 	}
 
 	if len(files) == 0 {
-		return nil, nil
+		return nil
 	}
 
-	repoName := filepath.Base(path)
+	repoName := filepath.Base(result.RootPath)
+	id := s.Identify(result.RootPath)
 	return []RepoExport{
 		{
-			Name:  repoName,
-			Path:  path,
-			Files: files,
+			Name:       repoName,
+			Path:       result.RootPath,
+			Remotes:    id.Remotes,
+			RootCommit: id.RootCommit,
+			Files:      files,
 		},
-	}, nil
+	}
 }
 
 func collectReposRecursive(s *scanner.Scanner, rootPath string) ([]RepoExport, error) {
 	var repos []RepoExport
 
-	err := filepath.Walk(rootPath, func(path string, info os.FileInfo, err error) error {
+	err := s.ScanRecursive(rootPath, exportJobs, func(result *scanner.ScanResult, err error) {
 		if err != nil {
-			return nil
+			fmt.Fprintf(os.Stderr, "Warning: could not scan %v; its files are not exported\n", err)
+			return
 		}
+		repos = append(repos, repoExportFromResult(s, result)...)
+	})
 
-		if info.IsDir() && info.Name() == ".git" {
-			repoPath := filepath.Dir(path)
-			repoExports, err := collectSingleRepo(s, repoPath)
-			if err == nil && len(repoExports) > 0 {
-				repos = append(repos, repoExports...)
-			}
-			return filepath.SkipDir
+	return repos, err
+}
+
+// repoIDUnsafe matches characters not allowed in repository IDs
+var repoIDUnsafe = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// assignRepoIDs gives each repository an ID derived from its path relative
+// to the scan root, so "work/api" and "oss/api" become "work-api" and
+// "oss-api". Repositories are in path order, which keeps IDs stable
+// between exports of the same tree.
+func assignRepoIDs(rootPath string, repos []RepoExport) {
+	used := make(map[string]bool)
+	for i := range repos {
+		rel, ok := relativeBelow(rootPath, repos[i].Path)
+		if !ok || rel == "." {
+			rel = repos[i].Name
 		}
 
-		// Skip common non-repo directories
-		if info.IsDir() {
-			skipDirs := []string{"node_modules", "vendor", ".cache", "__pycache__"}
-			for _, skip := range skipDirs {
-				if info.Name() == skip {
-					return filepath.SkipDir
-				}
-			}
+		base := strings.Trim(repoIDUnsafe.ReplaceAllString(rel, "-"), "-.")
+		if base == "" {
+			base = "repo"
 		}
 
-		return nil
-	})
+		id := base
+		for n := 2; used[id]; n++ {
+			id = fmt.Sprintf("%s-%d", base, n)
+		}
+		used[id] = true
+		repos[i].ID = id
+	}
+}
 
-	return repos, err
+// setPortablePaths records each repository's path relative to the scan
+// root and to the home directory, so import can rebase them on a machine
+// with a different layout or user name
+func setPortablePaths(rootPath string, repos []RepoExport) {
+	home, _ := os.UserHomeDir()
+	for i := range repos {
+		if rel, ok := relativeBelow(rootPath, repos[i].Path); ok {
+			repos[i].RelPath = rel
+		}
+		if home != "" {
+			if rel, ok := relativeBelow(home, repos[i].Path); ok && rel != "." {
+				repos[i].HomePath = rel
+			}
+		}
+	}
 }
 
-func createExportZip(outputPath string, repos []RepoExport) error {
-	file, err := os.Create(outputPath)
-	if err != nil {
-		return err
+// relativeBelow returns path relative to base, slash-separated, if path is
+// base or inside it
+func relativeBelow(base, path string) (string, bool) {
+	rel, err := filepath.Rel(base, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return filepath.ToSlash(rel), true
+}
+
+// writeExportArchive writes patterns.yaml, the files of each repository
+// and, last, the manifest. Progress is reported to out.
+func writeExportArchive(aw archiveWriter, repos []RepoExport, out io.Writer) error {
+	// Write patterns.yaml if it exists
+	patternsPath, _ := config.PatternsFilePath()
+	if patternsData, err := os.ReadFile(patternsPath); err == nil {
+		if err := aw.AddData("patterns.yaml", patternsData); err != nil {
+			return err
+		}
 	}
-	defer file.Close()
 
-	zipWriter := zip.NewWriter(file)
-	defer zipWriter.Close()
+	// Write files, recording a checksum and attributes for each one.
+	// Symlinks are only recorded in the manifest, and files that can't be
+	// read are left out of it.
+	for i := range repos {
+		repo := &repos[i]
+		fmt.Fprintf(out, "  Exporting %s (%d files)\n", repo.ID, len(repo.Files))
+
+		var added []string
+		repo.Checksums = make(map[string]FileChecksum)
+		repo.Attrs = make(map[string]FileAttrs)
+		for _, filePath := range repo.Files {
+			fullPath := filepath.Join(repo.Path, filePath)
+			name := path.Join("files", repo.ID, filepath.ToSlash(filePath))
+
+			attrs, err := exportFile(aw, repo, fullPath, filePath, name)
+			if err != nil {
+				fmt.Fprintf(out, "    Warning: could not add %s: %v\n", filePath, err)
+				continue
+			}
+			added = append(added, filePath)
+			repo.Attrs[filePath] = attrs
+		}
+		repo.Files = added
+	}
 
 	// Create manifest
 	hostname, _ := os.Hostname()
 	manifest := Manifest{
-		Version:   1,
+		Version:   manifestVersion,
 		CreatedAt: time.Now(),
 		Machine:   hostname,
 		Repos:     repos,
@@ -198,65 +513,66 @@ func createExportZip(outputPath string, repos []RepoExport) error {
 	}
 
 	// Write manifest
-	manifestWriter, err := zipWriter.Create("manifest.yaml")
-	if err != nil {
+	if err := aw.AddData("manifest.yaml", manifestData); err != nil {
 		return err
 	}
-	manifestWriter.Write(manifestData)
 
-	// Write patterns.yaml if it exists
-	patternsPath, _ := config.PatternsFilePath()
-	if patternsData, err := os.ReadFile(patternsPath); err == nil {
-		patternsWriter, err := zipWriter.Create("patterns.yaml")
-		if err != nil {
-			return err
-		}
-		patternsWriter.Write(patternsData)
-	}
-
-	// Write files
-	for _, repo := range repos {
-		fmt.Printf("  Exporting %s (%d files)\n", repo.Name, len(repo.Files))
+	return aw.Close()
+}
 
-		for _, filePath := range repo.Files {
-			fullPath := filepath.Join(repo.Path, filePath)
-			zipPath := filepath.Join("files", repo.Name, filePath)
+// exportFile adds a file to the archive, or records the target of a
+// symlink, and returns its attributes
+func exportFile(aw archiveWriter, repo *RepoExport, fullPath, filePath, name string) (FileAttrs, error) {
+	info, err := os.Lstat(fullPath)
+	if err != nil {
+		return FileAttrs{}, err
+	}
+	attrs := fileAttrs(info)
 
-			if err := addFileToZip(zipWriter, fullPath, zipPath); err != nil {
-				fmt.Printf("    Warning: could not add %s: %v\n", filePath, err)
-				continue
-			}
+	if info.Mode()&os.ModeSymlink != 0 {
+		if attrs.Link, err = os.Readlink(fullPath); err != nil {
+			return FileAttrs{}, err
 		}
+		return attrs, nil
 	}
 
-	return nil
+	sum, err := addFileToArchive(aw, fullPath, name)
+	if err != nil {
+		return FileAttrs{}, err
+	}
+	repo.Checksums[filePath] = sum
+	return attrs, nil
 }
 
-func addFileToZip(zipWriter *zip.Writer, sourcePath, zipPath string) error {
+// addFileToArchive copies a file into the archive and returns its checksum
+func addFileToArchive(aw archiveWriter, sourcePath, name string) (FileChecksum, error) {
 	file, err := os.Open(sourcePath)
 	if err != nil {
-		return err
+		return FileChecksum{}, err
 	}
 	defer file.Close()
 
 	info, err := file.Stat()
 	if err != nil {
-		return err
+		return FileChecksum{}, err
 	}
 
-	header, err := zip.FileInfoHeader(info)
-	if err != nil {
-		return err
+	hash := sha256.New()
+	counter := &countingReader{r: io.TeeReader(file, hash)}
+	if err := aw.AddFile(name, info, counter); err != nil {
+		return FileChecksum{}, err
 	}
+	return FileChecksum{SHA256: hex.EncodeToString(hash.Sum(nil)), Size: counter.n}, nil
+}
 
-	header.Name = zipPath
-	header.Method = zip.Deflate
-
-	writer, err := zipWriter.CreateHeader(header)
-	if err != nil {
-		return err
-	}
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
 
-	_, err = io.Copy(writer, file)
-	return err
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
 }
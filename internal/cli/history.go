@@ -0,0 +1,404 @@
+package cli
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/O6lvl4/igloc/internal/config"
+	"gopkg.in/yaml.v3"
+)
+
+// maxImportHistory is the number of past imports kept for undo
+const maxImportHistory = 20
+
+// importRecord is the undo log of one import: the state of every path it
+// touched before it ran, with copies of the files it replaced
+type importRecord struct {
+	ID       string         `yaml:"id"`
+	Archive  string         `yaml:"archive"`
+	Time     time.Time      `yaml:"time"`
+	Complete bool           `yaml:"complete"`
+	Undone   bool           `yaml:"undone,omitempty"`
+	Changes  []importChange `yaml:"changes"`
+	Dirs     []string       `yaml:"dirs,omitempty"` // created by the import
+
+	dir     string
+	touched map[string]bool
+}
+
+// importChange is the state of a path before an import and what the
+// import left there
+type importChange struct {
+	Path      string `yaml:"path"`
+	Existed   bool   `yaml:"existed"`
+	Backup    string `yaml:"backup,omitempty"` // copy of the previous content, relative to the record
+	FileAttrs `yaml:",inline"`
+	After     string `yaml:"after,omitempty"` // see pathState
+}
+
+// newImportRecord starts the undo log of an import
+func newImportRecord(archive string) (*importRecord, error) {
+	root, err := config.ImportHistoryDir()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	id := now.Format("20060102-150405")
+	for n := 2; dirExists(filepath.Join(root, id)); n++ {
+		id = fmt.Sprintf("%s-%d", now.Format("20060102-150405"), n)
+	}
+
+	if abs, err := filepath.Abs(archive); err == nil && archive != stdioPath {
+		archive = abs
+	}
+	r := &importRecord{
+		ID:      id,
+		Archive: archive,
+		Time:    now,
+		dir:     filepath.Join(root, id),
+		touched: make(map[string]bool),
+	}
+	// Replaced secrets are kept here, so only the user can read them
+	if err := os.MkdirAll(r.dir, 0700); err != nil {
+		return nil, err
+	}
+	return r, r.save()
+}
+
+// capture records the state of path before the import first changes it
+func (r *importRecord) capture(path string) error {
+	if r == nil || r.touched[path] {
+		return nil
+	}
+	if err := requireAbs(path); err != nil {
+		return err
+	}
+	r.touched[path] = true
+
+	change := importChange{Path: path}
+	info, err := os.Lstat(path)
+	switch {
+	case os.IsNotExist(err):
+		// Undo removes it
+	case err != nil:
+		return err
+	case info.Mode()&os.ModeSymlink != 0:
+		change.Existed = true
+		change.FileAttrs = fileAttrs(info)
+		if change.Link, err = os.Readlink(path); err != nil {
+			return err
+		}
+	case info.Mode().IsRegular():
+		change.Existed = true
+		change.FileAttrs = fileAttrs(info)
+		change.Backup = filepath.Join("files", fmt.Sprint(len(r.Changes)))
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		backupPath := filepath.Join(r.dir, change.Backup)
+		if err := os.MkdirAll(filepath.Dir(backupPath), 0700); err != nil {
+			return err
+		}
+		if err := writeTempFile(backupPath, data, 0600, time.Time{}); err != nil {
+			return fmt.Errorf("failed to keep a copy of %s: %w", path, err)
+		}
+	default:
+		return fmt.Errorf("%s is not a regular file", path)
+	}
+
+	r.Changes = append(r.Changes, change)
+	return r.save()
+}
+
+// addDirs records directories created by the import
+func (r *importRecord) addDirs(dirs []string) error {
+	if r == nil || len(dirs) == 0 {
+		return nil
+	}
+	if err := requireAbs(dirs...); err != nil {
+		return err
+	}
+	r.Dirs = append(r.Dirs, dirs...)
+	return r.save()
+}
+
+// finish records what the import left at each path so undo can tell
+// whether a file was changed afterwards. Imports that changed nothing
+// are not kept.
+func (r *importRecord) finish() error {
+	if r == nil {
+		return nil
+	}
+	if len(r.Changes) == 0 {
+		return r.discard()
+	}
+	for i := range r.Changes {
+		r.Changes[i].After = pathState(r.Changes[i].Path)
+	}
+	r.Complete = true
+	if err := r.save(); err != nil {
+		return err
+	}
+	return pruneImportHistory()
+}
+
+// discard deletes the record, e.g. when a staged import is rolled back
+func (r *importRecord) discard() error {
+	if r == nil {
+		return nil
+	}
+	return os.RemoveAll(r.dir)
+}
+
+func (r *importRecord) save() error {
+	data, err := yaml.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(r.dir, "record.yaml"), data, 0600)
+}
+
+// requireAbs refuses relative paths in undo records and journals, which
+// would be resolved against whatever directory undo or resume runs in
+func requireAbs(paths ...string) error {
+	for _, path := range paths {
+		if !filepath.IsAbs(path) {
+			return fmt.Errorf("refusing to record relative path %s", path)
+		}
+	}
+	return nil
+}
+
+// pathState describes what is at path: "" if nothing, "link:<target>" for
+// a symlink, "sha256:<digest>" for a file
+func pathState(path string) string {
+	info, err := os.Lstat(path)
+	switch {
+	case err != nil:
+		return ""
+	case info.Mode()&os.ModeSymlink != 0:
+		target, _ := os.Readlink(path)
+		return "link:" + target
+	case info.IsDir():
+		return "dir"
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// loadImportHistory returns the recorded imports, newest first
+func loadImportHistory() ([]*importRecord, error) {
+	root, err := config.ImportHistoryDir()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(root)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var records []*importRecord
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		dir := filepath.Join(root, entry.Name())
+		data, err := os.ReadFile(filepath.Join(dir, "record.yaml"))
+		if err != nil {
+			continue // not a record
+		}
+		var r importRecord
+		if err := yaml.Unmarshal(data, &r); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: invalid import record %s: %v\n", dir, err)
+			continue
+		}
+		r.dir = dir
+		records = append(records, &r)
+	}
+
+	sort.Slice(records, func(i, j int) bool { return records[i].Time.After(records[j].Time) })
+	return records, nil
+}
+
+// pruneImportHistory deletes the oldest records beyond maxImportHistory
+func pruneImportHistory() error {
+	records, err := loadImportHistory()
+	if err != nil {
+		return err
+	}
+	for i := maxImportHistory; i < len(records); i++ {
+		if err := os.RemoveAll(records[i].dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// printImportHistory lists past imports, newest first
+func printImportHistory() error {
+	records, err := loadImportHistory()
+	if err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		fmt.Println("No imports recorded.")
+		return nil
+	}
+
+	fmt.Printf("%-17s  %-19s  %7s  %s\n", "ID", "DATE", "CHANGES", "ARCHIVE")
+	for _, r := range records {
+		var notes []string
+		if r.Undone {
+			notes = append(notes, "undone")
+		}
+		if !r.Complete {
+			notes = append(notes, "incomplete")
+		}
+		note := ""
+		if len(notes) > 0 {
+			note = " (" + strings.Join(notes, ", ") + ")"
+		}
+		fmt.Printf("%-17s  %-19s  %7d  %s%s\n", r.ID, r.Time.Format("2006-01-02 15:04:05"), len(r.Changes), r.Archive, note)
+	}
+	return nil
+}
+
+// findImportRecord returns the record with id, or the newest one that was
+// not undone when id is empty
+func findImportRecord(id string) (*importRecord, error) {
+	records, err := loadImportHistory()
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range records {
+		if id == "" && !r.Undone || r.ID == id {
+			return r, nil
+		}
+	}
+	if id == "" {
+		return nil, fmt.Errorf("no import to undo")
+	}
+	return nil, fmt.Errorf("no import with id %s (see igloc import --history)", id)
+}
+
+// undoImport puts back what an import replaced and removes what it
+// created. Paths changed since the import are left alone.
+func undoImport(id string, yes, dryRun bool) error {
+	r, err := findImportRecord(id)
+	if err != nil {
+		return err
+	}
+	if r.Undone {
+		return fmt.Errorf("import %s was already undone", r.ID)
+	}
+
+	fmt.Printf("Import %s of %s (%s)\n\n", r.ID, r.Archive, r.Time.Format("2006-01-02 15:04:05"))
+	if !r.Complete {
+		fmt.Println("Warning: this import did not finish; files changed since then can't be detected.")
+	}
+
+	// Newest change first, so a backup moved aside is restored last
+	changes := make([]importChange, len(r.Changes))
+	for i, c := range r.Changes {
+		changes[len(changes)-1-i] = c
+	}
+
+	var apply []importChange
+	for _, c := range changes {
+		if r.Complete && pathState(c.Path) != c.After {
+			fmt.Printf("  ! %s (changed since the import, left as is)\n", c.Path)
+			continue
+		}
+		switch {
+		case !c.Existed:
+			fmt.Printf("  - %s (remove)\n", c.Path)
+		case c.Link != "":
+			fmt.Printf("  ↺ %s (restore link to %s)\n", c.Path, c.Link)
+		default:
+			fmt.Printf("  ↺ %s (restore)\n", c.Path)
+		}
+		apply = append(apply, c)
+	}
+	fmt.Println()
+
+	if dryRun {
+		fmt.Println("Dry run - nothing was undone.")
+		return nil
+	}
+	if len(apply) == 0 {
+		fmt.Println("Nothing to undo.")
+		return nil
+	}
+	if !yes {
+		fmt.Print("Undo these changes? [y/N] ")
+		response, _ := stdinReader.ReadString('\n')
+		response = strings.TrimSpace(strings.ToLower(response))
+		if response != "y" && response != "yes" {
+			fmt.Println("Undo cancelled.")
+			return nil
+		}
+	}
+
+	tx := &importTx{}
+	failed := 0
+	for _, c := range apply {
+		if err := r.revert(tx, c); err != nil {
+			fmt.Printf("  ✗ %s: %v\n", c.Path, err)
+			failed++
+		}
+	}
+	for i := len(r.Dirs) - 1; i >= 0; i-- {
+		os.Remove(r.Dirs[i]) // only removed if empty
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d changes could not be undone", failed)
+	}
+	r.Undone = true
+	if err := r.save(); err != nil {
+		return err
+	}
+	fmt.Printf("Undid %d changes.\n", len(apply))
+	return nil
+}
+
+// revert restores the state of a path before the import
+func (r *importRecord) revert(tx *importTx, c importChange) error {
+	switch {
+	case !c.Existed:
+		if err := os.Remove(c.Path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	case c.Link != "":
+		if err := tx.MkdirAll(filepath.Dir(c.Path)); err != nil {
+			return err
+		}
+		return tx.Symlink(c.Link, c.Path)
+	default:
+		data, err := os.ReadFile(filepath.Join(r.dir, c.Backup))
+		if err != nil {
+			return fmt.Errorf("saved copy is missing: %w", err)
+		}
+		mode, _ := c.perm()
+		if err := tx.MkdirAll(filepath.Dir(c.Path)); err != nil {
+			return err
+		}
+		return tx.WriteFile(c.Path, data, mode, c.ModTime)
+	}
+}
@@ -1,58 +1,221 @@
 package cli
 
 import (
-	"archive/zip"
 	"bufio"
+	"bytes"
+	"crypto/ecdh"
+	"crypto/sha256"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/O6lvl4/igloc/internal/config"
+	"github.com/O6lvl4/igloc/internal/encrypt"
+	"github.com/O6lvl4/igloc/internal/scanner"
 	"github.com/spf13/cobra"
 	"gopkg.in/yaml.v3"
 )
 
 var (
-	importYes     bool
-	importDryRun  bool
-	importBaseDir string
+	importYes      bool
+	importDryRun   bool
+	importBaseDir  string
+	importIdentity string
+	importConflict string
+	importMergeEnv bool
+	importSearch   string
+	importAtomic   bool
+	importResume   bool
+	importUndo     string
+	importHistory  bool
+	importOnly     []string
+	importSelect   bool
+
+	importAllowExternalLinks bool
 )
 
+// undoLast is the --undo value when no import ID is given
+const undoLast = "last"
+
+// stdinReader is shared by all prompts so buffered input is not lost
+var stdinReader = bufio.NewReader(os.Stdin)
+
 // NewImportCmd creates the import command
 func NewImportCmd() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "import [archive.zip]",
-		Short: "Import ignored files from a zip archive",
-		Long: `Import gitignored files (secrets, configs) from a zip archive.
+		Use:   "import [archive]",
+		Short: "Import ignored files from an archive",
+		Long: `Import gitignored files (secrets, configs) from an archive.
 
 This restores secret files that were exported from another machine.
-By default, it will ask for confirmation before overwriting files.
+Each repository is restored into its original path if that is still a
+clone of the same repository, or into the same path relative to your home
+directory. Otherwise clones under --search (default: current directory)
+are matched by remote URL or root commit, so files land in the right
+checkout whatever the directory layout. With --base, the layout below the
+export's scan root is recreated under the base directory. The mapping is
+shown before anything is written.
+By default, it asks for confirmation and then, for each existing file
+with different content, shows a diff and asks what to do. Files whose
+content is identical are skipped.
+
+--on-conflict sets what happens to existing files:
+  skip       keep the existing file
+  overwrite  replace it
+  backup     rename it to <file>.igloc-backup-<time>, then replace it
+  rename     write the archived file next to it as <file>.imported
+  prompt     ask per file (default; overwrite with --yes)
+
+With --merge-env, existing env files are merged key by key instead:
+keys missing locally are added, and for keys whose values differ the
+--on-conflict strategy decides (prompt asks per key, overwrite and
+backup take the archive's value, skip and rename keep the local one).
 
 Examples:
   igloc import backup.zip              # Import with confirmation
   igloc import --yes backup.zip        # Import without confirmation
   igloc import --dry-run backup.zip    # Show what would be imported
-  igloc import --base ~/projects backup.zip  # Specify base directory`,
-		Args: cobra.ExactArgs(1),
+  igloc import --base ~/projects backup.zip  # Specify base directory
+  igloc import --on-conflict backup backup.zip  # Keep copies of replaced files
+  igloc import --search ~/src backup.zip     # Find clones under ~/src
+  igloc import backup.tar.gz                 # Any format igloc export writes
+  ssh host igloc export - | igloc import -y -   # Read the archive from stdin
+
+Zip, tar.gz and directory archives are detected automatically. "-" reads
+the archive from stdin; since prompts can't share stdin with the archive,
+it needs --yes or --dry-run and no prompt strategy.
+
+Encrypted archives are detected automatically. They are decrypted with
+the identity file (default: ~/.config/igloc/identity.key) when it holds a
+matching key, otherwise with a passphrase (prompted, or read from
+IGLOC_PASSPHRASE). Archives that fail authentication are refused.
+
+Each file is checked against the SHA-256 digest in the manifest before it
+is written; files that don't match are not imported.
+
+Each file is written to a temporary file and renamed into place, so an
+interrupted import never leaves a half-written file. With --atomic, the
+whole import is staged first and either fully applied or rolled back; if
+it is interrupted, igloc import --resume finishes or rolls it back.
+
+File modes and modification times recorded in the manifest are restored,
+and symlinks are recreated. Symlinks with absolute targets are refused,
+and so are targets outside the repository unless --allow-external-links
+is given. Key files that would be readable by others are flagged.
+
+Every import saves the files it replaces under ~/.config/igloc/imports,
+so it can be undone: igloc import --undo reverts the last import, or the
+one with the given ID from igloc import --history. Files changed since the
+import are left alone. The last 20 imports are kept.
+
+  igloc import --history               # List past imports
+  igloc import --undo                  # Undo the last import
+  igloc import --undo 20250101-120000  # Undo a specific import
+
+To import only part of an archive, --only takes a repository ID glob,
+optionally followed by a colon and a glob for files in it. It can be
+repeated. --select lists the files to pick them interactively.
+
+  igloc import --only 'work-*' backup.zip          # Only repos matching work-*
+  igloc import --only 'api:.env*' backup.zip       # Only .env files of api
+  igloc import --select backup.zip                 # Choose files interactively`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			switch {
+			case importResume || importHistory:
+				return cobra.NoArgs(cmd, args)
+			case importUndo != "":
+				return cobra.MaximumNArgs(1)(cmd, args)
+			}
+			return cobra.ExactArgs(1)(cmd, args)
+		},
 		RunE: runImport,
 	}
 
 	cmd.Flags().BoolVarP(&importYes, "yes", "y", false, "Import without confirmation")
 	cmd.Flags().BoolVar(&importDryRun, "dry-run", false, "Show what would be imported without actually importing")
 	cmd.Flags().StringVar(&importBaseDir, "base", "", "Base directory for imports (default: original paths or current directory)")
+	cmd.Flags().StringVar(&importConflict, "on-conflict", "", "What to do with existing files: "+strings.Join(conflictStrategies, ", "))
+	cmd.Flags().BoolVar(&importMergeEnv, "merge-env", false, "Merge existing env files key by key instead of replacing them")
+	cmd.Flags().StringVar(&importSearch, "search", ".", "Directory to search for clones of the archived repositories")
+	cmd.Flags().StringArrayVar(&importOnly, "only", nil, "Only import repositories matching this glob, or files matching repo:glob (repeatable)")
+	cmd.Flags().BoolVar(&importSelect, "select", false, "Pick the repositories and files to import from a list")
+	cmd.Flags().BoolVar(&importAllowExternalLinks, "allow-external-links", false, "Recreate archived symlinks whose target is outside the repository")
+	cmd.Flags().BoolVar(&importAtomic, "atomic", false, "Stage the whole import and apply it all or nothing")
+	cmd.Flags().BoolVar(&importResume, "resume", false, "Finish or roll back an interrupted --atomic import")
+	cmd.Flags().StringVar(&importUndo, "undo", "", "Undo the last import, or the one with this ID")
+	cmd.Flags().Lookup("undo").NoOptDefVal = undoLast
+	cmd.Flags().BoolVar(&importHistory, "history", false, "List past imports that can be undone")
+	cmd.Flags().StringVar(&importIdentity, "identity", "", "Private key file for encrypted archives (default: ~/.config/igloc/identity.key)")
 
 	return cmd
 }
 
 func runImport(cmd *cobra.Command, args []string) error {
+	switch {
+	case importResume:
+		return resumeImport()
+	case importHistory:
+		return printImportHistory()
+	case importUndo != "":
+		// "--undo ID" leaves the ID as an argument
+		id := importUndo
+		if id == undoLast {
+			id = ""
+			if len(args) == 1 {
+				id = args[0]
+			}
+		}
+		return undoImport(id, importYes, importDryRun)
+	}
 	archivePath := args[0]
 
-	// Open zip file
-	reader, err := zip.OpenReader(archivePath)
+	// Files of an interrupted import may be half applied
+	if journal, err := loadImportJournal(); err != nil {
+		return err
+	} else if journal != nil && !importDryRun {
+		return fmt.Errorf("an interrupted import of %s is pending; run igloc import --resume first", journal.Archive)
+	}
+
+	strategy := importConflict
+	if strategy == "" {
+		strategy = conflictPrompt
+		if importYes {
+			strategy = conflictOverwrite
+		}
+	}
+	if !containsString(conflictStrategies, strategy) {
+		return fmt.Errorf("invalid --on-conflict value: %s (use %s)", strategy, strings.Join(conflictStrategies, ", "))
+	}
+
+	onlyFilters, err := parseOnlyFilters(importOnly)
+	if err != nil {
+		return err
+	}
+
+	if archivePath == stdioPath && !importDryRun && (!importYes || strategy == conflictPrompt || importSelect) {
+		return fmt.Errorf("reading the archive from stdin needs --yes or --dry-run, a --on-conflict strategy other than prompt, and no --select")
+	}
+
+	// Env and key files are recognized by the classification rules. They
+	// are only required for --merge-env; otherwise key files just aren't
+	// checked for loose permissions.
+	rules, err := scanner.LoadRuleset()
+	if err != nil && importMergeEnv {
+		return err
+	}
+	var envRules *scanner.Ruleset
+	if importMergeEnv {
+		envRules = rules
+	}
+
+	// Open archive
+	reader, err := openArchive(archivePath, importIdentity)
 	if err != nil {
-		return fmt.Errorf("failed to open archive: %w", err)
+		return err
 	}
 	defer reader.Close()
 
@@ -69,35 +232,100 @@ func runImport(cmd *cobra.Command, args []string) error {
 	fmt.Printf("Repositories: %d\n", len(manifest.Repos))
 	fmt.Println()
 
-	// Show what will be imported
-	totalFiles := 0
+	// Show what will be imported, collecting the files for --select
+	selection := selectOnly(manifest, onlyFilters)
+	var items []*selectItem
+	totalFiles, rejected, missing := 0, 0, 0
 	for _, repo := range manifest.Repos {
-		fmt.Printf("📂 %s\n", repo.Name)
+		if selection != nil && len(selection[repo.ID]) == 0 {
+			continue
+		}
+
+		header := "📂 " + repo.ID
+		if dest, err := locateRepo(repo); err == nil {
+			// Show how the exported location maps onto this machine
+			if repo.Path != "" && repo.Path != dest.Root {
+				header = fmt.Sprintf("📂 %s: %s → %s (%s)", repo.ID, repo.Path, dest.Root, dest.Reason)
+			} else {
+				header = fmt.Sprintf("📂 %s → %s (%s)", repo.ID, dest.Root, dest.Reason)
+			}
+		}
+		fmt.Println(header)
+
+		var files []*selectItem
 		for _, file := range repo.Files {
-			destPath := resolveDestPath(repo, file)
-			exists := fileExists(destPath)
-			status := ""
-			if exists {
-				status = " (overwrite)"
+			if !selection.has(repo.ID, file) {
+				continue
+			}
+			line, err := previewFile(reader, repo, file, strategy, rules, envRules)
+			if errors.Is(err, errMissingEntry) {
+				// Counted as failed, as import does
+				fmt.Printf("   ✗ %s (%v)\n", file, err)
+				missing++
+				continue
+			}
+			if err != nil {
+				fmt.Printf("   ✗ %s (rejected: %v)\n", file, err)
+				rejected++
+				continue
 			}
-			fmt.Printf("   %s%s\n", file, status)
+			fmt.Printf("   %s\n", line)
+			files = append(files, &selectItem{repo: repo.ID, file: file, label: line, selected: true})
 			totalFiles++
 		}
+		if len(files) > 0 {
+			items = append(items, &selectItem{repo: repo.ID, label: header, selected: true})
+			items = append(items, files...)
+		}
+		fmt.Println()
+	}
+
+	if unlisted := unlistedEntries(reader, manifest); selection == nil && len(unlisted) > 0 {
+		for _, name := range unlisted {
+			fmt.Printf("✗ %s (rejected: not listed in the manifest)\n", name)
+		}
+		rejected += len(unlisted)
+		fmt.Println()
+	}
+
+	if line := previewPatterns(reader); line != "" {
+		fmt.Println(line)
 		fmt.Println()
 	}
 
-	fmt.Printf("Total: %d files\n\n", totalFiles)
+	fmt.Printf("Total: %d files\n", totalFiles)
+	if missing > 0 {
+		fmt.Printf("Failed: %d files are missing from the archive\n", missing)
+	}
+	if rejected > 0 {
+		fmt.Printf("Rejected: %d entries will not be imported\n", rejected)
+	}
+	fmt.Println()
 
 	if importDryRun {
 		fmt.Println("Dry run - no files were imported.")
 		return nil
 	}
 
-	// Confirm
-	if !importYes {
+	// Confirm, or let the user pick files
+	if importSelect {
+		picked, ok, err := selectInteractively(items)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			fmt.Println("Import cancelled.")
+			return nil
+		}
+		if len(picked) == 0 {
+			fmt.Println("No files selected.")
+			return nil
+		}
+		selection = picked
+		fmt.Printf("Importing %d selected files\n", countSelected(items))
+	} else if !importYes {
 		fmt.Print("Proceed with import? [y/N] ")
-		reader := bufio.NewReader(os.Stdin)
-		response, _ := reader.ReadString('\n')
+		response, _ := stdinReader.ReadString('\n')
 		response = strings.TrimSpace(strings.ToLower(response))
 		if response != "y" && response != "yes" {
 			fmt.Println("Import cancelled.")
@@ -105,161 +333,624 @@ func runImport(cmd *cobra.Command, args []string) error {
 		}
 	}
 
+	// Everything the import replaces is saved so it can be undone
+	record, err := newImportRecord(archivePath)
+	if err != nil {
+		return fmt.Errorf("failed to create the undo record: %w", err)
+	}
+
 	// Import files
-	if err := importFiles(reader, manifest); err != nil {
+	tx := &importTx{record: record}
+	if importAtomic {
+		if tx, err = newStagedTx(archivePath, record); err != nil {
+			record.discard()
+			return err
+		}
+		fmt.Println("Staging files...")
+	}
+	summary, err := importFiles(tx, reader, manifest, selection, strategy, rules, envRules)
+	if err != nil {
+		tx.Rollback()
 		return err
 	}
-
-	// Import patterns.yaml
-	if err := importPatterns(reader); err != nil {
+	if err := importPatterns(tx, reader); err != nil {
 		fmt.Printf("Warning: could not import patterns: %v\n", err)
 	}
+	if tx.staged() {
+		if len(summary.Failed) > 0 {
+			if err := tx.Rollback(); err != nil {
+				return fmt.Errorf("failed to roll back: %w", err)
+			}
+			// Not a usage error; main reports the error once
+			cmd.SilenceUsage = true
+			cmd.SilenceErrors = true
+			return fmt.Errorf("%d files failed; the import was rolled back and nothing was changed", len(summary.Failed))
+		}
+		if err := tx.Commit(); err != nil {
+			// A commit that was rolled back leaves no journal to resume
+			if journal, _ := loadImportJournal(); journal == nil {
+				record.discard()
+			}
+			return fmt.Errorf("failed to apply import: %w", err)
+		}
+	}
+	if err := record.finish(); err != nil {
+		fmt.Printf("Warning: could not save the undo record: %v\n", err)
+	}
 
-	fmt.Println("\nImport complete!")
+	summary.print()
+	if len(summary.Failed) > 0 {
+		fmt.Println("\nImport finished with errors.")
+	} else {
+		fmt.Println("\nImport complete!")
+	}
+	if len(record.Changes) > 0 {
+		fmt.Printf("Undo with: igloc import --undo %s\n", record.ID)
+	}
+	if len(summary.Failed) > 0 {
+		cmd.SilenceUsage = true
+		cmd.SilenceErrors = true
+		return fmt.Errorf("%d files could not be imported", len(summary.Failed))
+	}
 	return nil
 }
 
-func readManifest(reader *zip.ReadCloser) (*Manifest, error) {
-	for _, file := range reader.File {
-		if file.Name == "manifest.yaml" {
-			rc, err := file.Open()
-			if err != nil {
-				return nil, err
-			}
-			defer rc.Close()
+// errMissingEntry reports a manifest file that has no archive entry
+var errMissingEntry = errors.New("missing from archive")
 
-			data, err := io.ReadAll(rc)
-			if err != nil {
-				return nil, err
-			}
+// previewFile describes what importing a file will do, or why it is
+// rejected
+func previewFile(reader *archive, repo RepoExport, file, strategy string, rules, envRules *scanner.Ruleset) (string, error) {
+	if link := repo.Attrs[file].Link; link != "" {
+		return previewSymlink(repo, file, link, strategy)
+	}
 
-			var manifest Manifest
-			if err := yaml.Unmarshal(data, &manifest); err != nil {
-				return nil, err
-			}
+	destPath, err := resolveDestPath(repo, file)
+	if err != nil {
+		return "", err
+	}
+	entry, ok := reader.Lookup("files/" + repo.ID + "/" + file)
+	if !ok {
+		return "", errMissingEntry
+	}
+	if !entry.Mode.IsRegular() {
+		return "", fmt.Errorf("not a regular file")
+	}
 
-			return &manifest, nil
+	status := ""
+	if existing, err := os.ReadFile(destPath); err == nil {
+		status = fmt.Sprintf(" (exists: %s)", strategy)
+		if isDotenvFile(envRules, file) {
+			status = " (exists: merge keys)"
+		}
+		if data, err := entry.Read(); err == nil && bytes.Equal(existing, data) {
+			status = " (identical, skip)"
 		}
 	}
-	return nil, fmt.Errorf("manifest.yaml not found in archive")
+	if mode, ok := repo.Attrs[file].perm(); ok && isExposedKey(rules, file, mode) {
+		status += fmt.Sprintf(" ⚠ readable by others (%04o)", mode)
+	}
+	return file + status, nil
 }
 
-func resolveDestPath(repo RepoExport, filePath string) string {
-	if importBaseDir != "" {
-		// Use base directory + repo name + file path
-		return filepath.Join(importBaseDir, repo.Name, filePath)
+// openArchive opens an export archive from a file, a directory or stdin
+// ("-"), decrypting it if needed with the keys in identityPath (empty for
+// the default identity file)
+func openArchive(path, identityPath string) (*archive, error) {
+	if path != stdioPath && dirExists(path) {
+		a, err := readDirArchive(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open archive: %w", err)
+		}
+		return a, nil
 	}
 
-	// Check if original path exists
-	if dirExists(repo.Path) {
-		return filepath.Join(repo.Path, filePath)
+	in := io.Reader(os.Stdin)
+	var file *os.File // nil for stdin, which cannot be read in place
+	if path != stdioPath {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open archive: %w", err)
+		}
+		in, file = f, f
 	}
 
-	// Fall back to current directory + repo name
-	return filepath.Join(".", repo.Name, filePath)
+	a, err := readArchiveInput(bufio.NewReader(in), file, identityPath)
+	if err != nil {
+		if file != nil {
+			file.Close()
+		}
+		return nil, err
+	}
+	if file != nil {
+		a.closer = file
+	}
+	return a, nil
 }
 
-func importFiles(zipReader *zip.ReadCloser, manifest *Manifest) error {
-	// Build a map of repo names to their export info
-	repoMap := make(map[string]RepoExport)
-	for _, repo := range manifest.Repos {
-		repoMap[repo.Name] = repo
+// readArchiveInput streams a plain archive. Encrypted archives are read
+// into memory, since they are authenticated as a whole.
+func readArchiveInput(r *bufio.Reader, file *os.File, identityPath string) (*archive, error) {
+	head, _ := r.Peek(16)
+	if !encrypt.IsEncrypted(head) {
+		a, err := readArchiveStream(r, file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open archive: %w", err)
+		}
+		return a, nil
 	}
 
-	for _, file := range zipReader.File {
-		// Only process files in the files/ directory
-		if !strings.HasPrefix(file.Name, "files/") {
-			continue
-		}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open archive: %w", err)
+	}
+	if data, err = decryptArchive(data, identityPath); err != nil {
+		return nil, err
+	}
+	a, err := readArchiveData(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open archive: %w", err)
+	}
+	return a, nil
+}
 
-		// Parse path: files/repoName/path/to/file
-		relPath := strings.TrimPrefix(file.Name, "files/")
-		parts := strings.SplitN(relPath, "/", 2)
-		if len(parts) < 2 {
-			continue
+// decryptArchive tries the identity file first and only asks for a
+// passphrase when no identity matches
+func decryptArchive(data []byte, identityPath string) ([]byte, error) {
+	identities, err := loadIdentities(identityPath)
+	if err != nil {
+		return nil, err
+	}
+
+	needsPass, err := encrypt.NeedsPassphrase(data, identities)
+	if err != nil {
+		return nil, err
+	}
+
+	var passphrase []byte
+	if needsPass {
+		fmt.Println("Archive is encrypted.")
+		if passphrase, err = readPassphrase(false); err != nil {
+			return nil, err
 		}
+	}
+
+	plaintext, err := encrypt.Open(data, passphrase, identities)
+	if errors.Is(err, encrypt.ErrNoKey) {
+		return nil, fmt.Errorf("archive is encrypted for other recipients; use --identity with a matching key")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return plaintext, nil
+}
 
-		repoName := parts[0]
-		filePath := parts[1]
+// loadIdentities reads the private keys from identityPath or the default
+// identity file. A missing default file is not an error.
+func loadIdentities(identityPath string) ([]*ecdh.PrivateKey, error) {
+	path := identityPath
+	if path == "" {
+		var err error
+		if path, err = config.IdentityFilePath(); err != nil {
+			return nil, err
+		}
+	}
 
-		repo, ok := repoMap[repoName]
-		if !ok {
-			continue
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) && identityPath == "" {
+			return nil, nil
 		}
+		return nil, fmt.Errorf("failed to read identity: %w", err)
+	}
 
-		destPath := resolveDestPath(repo, filePath)
+	keys, err := encrypt.ParseIdentities(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid identity file %s: %w", path, err)
+	}
+	return keys, nil
+}
 
-		// Create parent directory
-		if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
-			fmt.Printf("  ✗ %s: %v\n", filePath, err)
-			continue
+func readManifest(reader *archive) (*Manifest, error) {
+	entry, ok := reader.Lookup("manifest.yaml")
+	if !ok {
+		return nil, fmt.Errorf("manifest.yaml not found in archive")
+	}
+
+	data, err := entry.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest Manifest
+	if err := yaml.Unmarshal(data, &manifest); err != nil {
+		return nil, err
+	}
+	if err := manifest.upgrade(); err != nil {
+		return nil, err
+	}
+
+	return &manifest, nil
+}
+
+// unlistedEntries returns the files/ entries of an archive that are not
+// regular files listed in the manifest. They are never imported.
+func unlistedEntries(reader *archive, manifest *Manifest) []string {
+	listed := make(map[string]bool)
+	for _, repo := range manifest.Repos {
+		for _, filePath := range repo.Files {
+			if repo.Attrs[filePath].Link == "" {
+				listed["files/"+repo.ID+"/"+filePath] = true
+			}
 		}
+	}
 
-		// Extract file
-		if err := extractFile(file, destPath); err != nil {
-			fmt.Printf("  ✗ %s: %v\n", filePath, err)
-			continue
+	var names []string
+	for _, entry := range reader.Entries {
+		if strings.HasPrefix(entry.Name, "files/") && !listed[entry.Name] {
+			names = append(names, entry.Name)
 		}
+	}
+	return names
+}
 
-		fmt.Printf("  ✓ %s\n", destPath)
+// upgrade brings an older manifest to the current version. Version 1 has
+// no repository IDs; its files are stored under the repository name.
+func (m *Manifest) upgrade() error {
+	switch m.Version {
+	case 1:
+		names := make(map[string]bool)
+		for i := range m.Repos {
+			if names[m.Repos[i].Name] {
+				fmt.Printf("Warning: several repositories are named %s; their files cannot be told apart in this version 1 archive\n", m.Repos[i].Name)
+			}
+			names[m.Repos[i].Name] = true
+			m.Repos[i].ID = m.Repos[i].Name
+		}
+	case manifestVersion:
+		ids := make(map[string]bool)
+		for _, repo := range m.Repos {
+			if ids[repo.ID] {
+				return fmt.Errorf("duplicate repository id %q", repo.ID)
+			}
+			ids[repo.ID] = true
+		}
+	default:
+		return fmt.Errorf("unsupported manifest version %d (this igloc reads versions 1-%d)", m.Version, manifestVersion)
 	}
 
+	m.Version = manifestVersion
 	return nil
 }
 
-func extractFile(zipFile *zip.File, destPath string) error {
-	rc, err := zipFile.Open()
+// resolveDestPath returns where a file of a repository is written. It
+// fails when the manifest or the file path would place the file outside
+// the repository's destination directory, or when the destination is a
+// symlink that writing would follow.
+func resolveDestPath(repo RepoExport, filePath string) (string, error) {
+	destPath, err := resolveLinkPath(repo, filePath)
 	if err != nil {
-		return err
+		return "", err
+	}
+	if info, err := os.Lstat(destPath); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		return "", fmt.Errorf("destination is a symlink")
+	}
+	return destPath, nil
+}
+
+// resolveLinkPath is resolveDestPath for archived symlinks, which replace
+// an existing destination instead of writing through it
+func resolveLinkPath(repo RepoExport, filePath string) (string, error) {
+	root, err := resolveRepoRoot(repo)
+	if err != nil {
+		return "", err
+	}
+	if err := validateEntryPath(filePath); err != nil {
+		return "", err
+	}
+
+	destPath := filepath.Join(root, filepath.FromSlash(filePath))
+	if err := checkWithinRoot(root, destPath); err != nil {
+		return "", err
+	}
+	return destPath, nil
+}
+
+// resolveRepoRoot returns the directory a repository's files go into
+func resolveRepoRoot(repo RepoExport) (string, error) {
+	dest, err := locateRepo(repo)
+	if err != nil {
+		return "", err
+	}
+	return dest.Root, nil
+}
+
+// validateRepoID accepts a single path component
+func validateRepoID(id string) error {
+	if id == "" || id == "." || id == ".." || strings.ContainsAny(id, `/\`) {
+		return fmt.Errorf("unsafe repository id %q in manifest", id)
+	}
+	return nil
+}
+
+// validateEntryPath accepts a relative, slash-separated path without ".."
+// components
+func validateEntryPath(path string) error {
+	switch {
+	case path == "":
+		return fmt.Errorf("empty path")
+	case strings.HasPrefix(path, "/") || filepath.IsAbs(path) || filepath.VolumeName(path) != "":
+		return fmt.Errorf("absolute path")
+	case strings.Contains(path, "\\"):
+		return fmt.Errorf("path contains a backslash")
 	}
-	defer rc.Close()
+	for _, part := range strings.Split(path, "/") {
+		if part == ".." {
+			return fmt.Errorf("path escapes the repository")
+		}
+		if part == "" || part == "." {
+			return fmt.Errorf("path is not clean")
+		}
+	}
+	return nil
+}
 
-	outFile, err := os.Create(destPath)
+// checkWithinRoot makes sure the directory of destPath stays inside root
+// once existing symlinks are resolved
+func checkWithinRoot(root, destPath string) error {
+	realRoot, err := evalExisting(root)
+	if err != nil {
+		return err
+	}
+	realDir, err := evalExisting(filepath.Dir(destPath))
 	if err != nil {
 		return err
 	}
-	defer outFile.Close()
 
-	// Preserve original permissions
-	if err := outFile.Chmod(zipFile.Mode()); err != nil {
-		// Non-fatal, continue
+	if !isWithin(realRoot, realDir) {
+		return fmt.Errorf("destination escapes the repository through a symlink")
 	}
+	return nil
+}
 
-	_, err = io.Copy(outFile, rc)
-	return err
+// isWithin reports whether path is root or below it
+func isWithin(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
 }
 
-func importPatterns(zipReader *zip.ReadCloser) error {
-	for _, file := range zipReader.File {
-		if file.Name == "patterns.yaml" {
-			rc, err := file.Open()
-			if err != nil {
-				return err
-			}
-			defer rc.Close()
+// evalExisting resolves symlinks in the longest existing prefix of path and
+// appends the part that does not exist yet
+func evalExisting(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
 
-			data, err := io.ReadAll(rc)
-			if err != nil {
-				return err
-			}
+	rest := ""
+	for {
+		real, err := filepath.EvalSymlinks(abs)
+		if err == nil {
+			return filepath.Join(real, rest), nil
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+		parent := filepath.Dir(abs)
+		if parent == abs {
+			return filepath.Join(abs, rest), nil
+		}
+		rest = filepath.Join(filepath.Base(abs), rest)
+		abs = parent
+	}
+}
 
-			// Ensure config directory exists
-			configDir, err := config.DefaultConfigDir()
-			if err != nil {
-				return err
+// importFiles extracts the selected files and recreates symlinks. Existing
+// files are handled by strategy, or merged key by key when envRules
+// classifies them as env files. rules is used to warn about key files that
+// end up readable by others.
+func importFiles(tx *importTx, reader *archive, manifest *Manifest, selection importSelection, strategy string, rules, envRules *scanner.Ruleset) (*importSummary, error) {
+	summary := &importSummary{}
+
+	// Files are extracted as the manifest lists them, so entries missing
+	// from a truncated archive are reported instead of skipped
+	for _, repo := range manifest.Repos {
+		for _, filePath := range repo.Files {
+			if repo.Attrs[filePath].Link != "" || !selection.has(repo.ID, filePath) {
+				continue
 			}
-			if err := os.MkdirAll(configDir, 0755); err != nil {
-				return err
+			importFile(tx, reader, repo, filePath, strategy, rules, envRules, summary)
+		}
+	}
+
+	if selection == nil {
+		for _, name := range unlistedEntries(reader, manifest) {
+			fmt.Printf("  ✗ %s: rejected: not listed in the manifest\n", name)
+		}
+	}
+
+	// Symlinks have no content in the archive; the manifest records them
+	for _, repo := range manifest.Repos {
+		for _, filePath := range repo.Files {
+			if link := repo.Attrs[filePath].Link; link != "" && selection.has(repo.ID, filePath) {
+				importSymlink(tx, repo, filePath, link, strategy, summary)
 			}
+		}
+	}
 
-			patternsPath, err := config.PatternsFilePath()
-			if err != nil {
-				return err
+	return summary, nil
+}
+
+// importFile extracts one regular file listed in the manifest
+func importFile(tx *importTx, reader *archive, repo RepoExport, filePath, strategy string, rules, envRules *scanner.Ruleset, summary *importSummary) {
+	name := "files/" + repo.ID + "/" + filePath
+	destPath, err := resolveDestPath(repo, filePath)
+	if err != nil {
+		fmt.Printf("  ✗ %s: rejected: %v\n", name, err)
+		return
+	}
+
+	file, ok := reader.Lookup(name)
+	if !ok {
+		fmt.Printf("  ✗ %s: %v\n", filePath, errMissingEntry)
+		summary.Failed = append(summary.Failed, destPath)
+		return
+	}
+	// Only regular files are extracted
+	if !file.Mode.IsRegular() {
+		fmt.Printf("  ✗ %s: rejected: not a regular file\n", name)
+		return
+	}
+
+	data, err := file.Read()
+	if err != nil {
+		fmt.Printf("  ✗ %s: %v\n", filePath, err)
+		summary.Failed = append(summary.Failed, destPath)
+		return
+	}
+
+	// Refuse files that don't match the manifest checksum
+	if _, err := verifyChecksum(repo, filePath, data); err != nil {
+		fmt.Printf("  ✗ %s: %v\n", filePath, err)
+		summary.Failed = append(summary.Failed, destPath)
+		return
+	}
+
+	// The manifest's mode and mtime win over what the format stored
+	mode, modTime := file.Mode.Perm(), time.Time{}
+	if attrs, ok := repo.Attrs[filePath]; ok {
+		if perm, ok := attrs.perm(); ok {
+			mode = perm
+		}
+		modTime = attrs.ModTime
+	}
+	write := func(path string) error {
+		return tx.WriteFile(path, data, mode, modTime)
+	}
+
+	existing, err := os.ReadFile(destPath)
+	if err == nil {
+		// Identical files need no decision
+		if sha256.Sum256(existing) == sha256.Sum256(data) {
+			summary.Unchanged = append(summary.Unchanged, destPath)
+			return
+		}
+
+		if isDotenvFile(envRules, filePath) {
+			if err := mergeEnvFile(tx, destPath, existing, data, strategy, summary); err != nil {
+				fmt.Printf("  ✗ %s: %v\n", filePath, err)
+				summary.Failed = append(summary.Failed, destPath)
 			}
+			return
+		}
+
+		action := strategy
+		if action == conflictPrompt {
+			action = promptConflict(destPath, existing, data)
+		}
+
+		written, err := writeConflictFile(tx, destPath, action, write, summary)
+		if err != nil {
+			fmt.Printf("  ✗ %s: %v\n", filePath, err)
+			summary.Failed = append(summary.Failed, destPath)
+			return
+		}
+		if written == "" {
+			fmt.Printf("  - %s (skipped)\n", destPath)
+		} else {
+			fmt.Printf("  ✓ %s\n", written)
+			warnExposedKey(rules, filePath, written, mode)
+		}
+		return
+	}
+
+	// Create parent directory
+	if err := tx.MkdirAll(filepath.Dir(destPath)); err != nil {
+		fmt.Printf("  ✗ %s: %v\n", filePath, err)
+		summary.Failed = append(summary.Failed, destPath)
+		return
+	}
+
+	// Extract file
+	if err := write(destPath); err != nil {
+		fmt.Printf("  ✗ %s: %v\n", filePath, err)
+		summary.Failed = append(summary.Failed, destPath)
+		return
+	}
+
+	summary.Created = append(summary.Created, destPath)
+	fmt.Printf("  ✓ %s\n", destPath)
+	warnExposedKey(rules, filePath, destPath, mode)
+}
+
+// isExposedKey reports whether rules classify path as a key file and mode
+// lets the group or others read it
+func isExposedKey(rules *scanner.Ruleset, path string, mode os.FileMode) bool {
+	if rules == nil || mode.Perm()&0044 == 0 {
+		return false
+	}
+	category, _ := rules.Classify(path)
+	return category == "key"
+}
+
+// warnExposedKey warns when a key file was written readable by others
+func warnExposedKey(rules *scanner.Ruleset, path, destPath string, mode os.FileMode) {
+	if isExposedKey(rules, path, mode) {
+		fmt.Printf("  ⚠ %s is readable by others (mode %04o); consider chmod 600\n", destPath, mode.Perm())
+	}
+}
+
+// previewPatterns describes what importing the archived patterns.yaml will
+// do, or returns "" when the archive has none
+func previewPatterns(reader *archive) string {
+	entry, ok := reader.Lookup("patterns.yaml")
+	if !ok {
+		return ""
+	}
+	patternsPath, err := config.PatternsFilePath()
+	if err != nil {
+		return ""
+	}
 
-			return os.WriteFile(patternsPath, data, 0644)
+	status := ""
+	if existing, err := os.ReadFile(patternsPath); err == nil {
+		status = " (exists: overwrite)"
+		if data, err := entry.Read(); err == nil && bytes.Equal(existing, data) {
+			status = " (identical, skip)"
 		}
 	}
+	return fmt.Sprintf("⚙ patterns.yaml → %s%s", patternsPath, status)
+}
+
+// importPatterns replaces the local patterns.yaml with the archived one.
+// It is written through tx, so it is staged with --atomic and restored by
+// --undo like the other files.
+func importPatterns(tx *importTx, reader *archive) error {
+	entry, ok := reader.Lookup("patterns.yaml")
+	if !ok {
+		return nil
+	}
+
+	data, err := entry.Read()
+	if err != nil {
+		return err
+	}
+
+	patternsPath, err := config.PatternsFilePath()
+	if err != nil {
+		return err
+	}
+	if existing, err := os.ReadFile(patternsPath); err == nil && bytes.Equal(existing, data) {
+		return nil
+	}
+
+	if err := tx.MkdirAll(filepath.Dir(patternsPath)); err != nil {
+		return err
+	}
+	if err := tx.WriteFile(patternsPath, data, 0644, time.Time{}); err != nil {
+		return err
+	}
+	fmt.Printf("  ✓ %s\n", patternsPath)
 	return nil
 }
 
@@ -0,0 +1,438 @@
+package cli
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// testEntry is a file in a test archive; entries with a link are symlinks
+type testEntry struct {
+	name string
+	data string
+	link string
+}
+
+// buildArchive writes a manifest and entries as a zip or tar.gz archive
+// without any of the checks export does
+func buildArchive(t *testing.T, format string, manifest *Manifest, entries []testEntry) []byte {
+	t.Helper()
+	manifestData, err := yaml.Marshal(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries = append([]testEntry{{name: "manifest.yaml", data: string(manifestData)}}, entries...)
+
+	var buf bytes.Buffer
+	switch format {
+	case formatZip:
+		zw := zip.NewWriter(&buf)
+		for _, e := range entries {
+			header := &zip.FileHeader{Name: e.name, Method: zip.Deflate}
+			content := e.data
+			header.SetMode(0644)
+			if e.link != "" {
+				header.SetMode(os.ModeSymlink | 0777)
+				content = e.link
+			}
+			w, err := zw.CreateHeader(header)
+			if err != nil {
+				t.Fatal(err)
+			}
+			io.WriteString(w, content)
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+	case formatTarGz:
+		gz := gzip.NewWriter(&buf)
+		tw := tar.NewWriter(gz)
+		for _, e := range entries {
+			header := &tar.Header{Name: e.name, Mode: 0644, Size: int64(len(e.data)), ModTime: time.Now()}
+			if e.link != "" {
+				header = &tar.Header{Name: e.name, Typeflag: tar.TypeSymlink, Linkname: e.link, Mode: 0777}
+			}
+			if err := tw.WriteHeader(header); err != nil {
+				t.Fatal(err)
+			}
+			if e.link == "" {
+				io.WriteString(tw, e.data)
+			}
+		}
+		if err := tw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := gz.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+// snapshot records every path below root with its content or link target,
+// without following symlinks
+func snapshot(t *testing.T, root string) map[string]string {
+	t.Helper()
+	state := make(map[string]string)
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		switch {
+		case d.Type()&fs.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			state[path] = "link:" + target
+			return err
+		case d.IsDir():
+			state[path] = "dir"
+		default:
+			data, err := os.ReadFile(path)
+			state[path] = "file:" + string(data)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return state
+}
+
+// captureStdout returns what f prints
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	done := make(chan []byte)
+	go func() {
+		data, _ := io.ReadAll(r)
+		done <- data
+	}()
+	f()
+	w.Close()
+	return string(<-done)
+}
+
+// importTestArchive imports an archive with --base set to base, as
+// igloc import --yes --on-conflict overwrite would
+func importTestArchive(t *testing.T, base string, data []byte) (*importSummary, string) {
+	t.Helper()
+	importBaseDir = base
+	importDestinations = make(map[string]repoDestination)
+	t.Cleanup(func() {
+		importBaseDir = ""
+		importDestinations = make(map[string]repoDestination)
+	})
+
+	reader, err := readArchiveData(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := readManifest(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var summary *importSummary
+	output := captureStdout(t, func() {
+		summary, err = importFiles(&importTx{}, reader, manifest, nil, conflictOverwrite, nil, nil)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return summary, output
+}
+
+func testManifest(repos ...RepoExport) *Manifest {
+	return &Manifest{Version: manifestVersion, CreatedAt: time.Now(), Repos: repos}
+}
+
+func TestImportRejectsMaliciousArchives(t *testing.T) {
+	tests := []struct {
+		name    string
+		repo    func(tmp string) RepoExport
+		entries []testEntry
+		setup   func(t *testing.T, tmp, repoRoot string)
+	}{
+		{
+			name:    "dot-dot in file path",
+			repo:    func(string) RepoExport { return RepoExport{ID: "r", Files: []string{"../../evil"}} },
+			entries: []testEntry{{name: "files/r/../../evil", data: "pwn"}},
+		},
+		{
+			name:    "unlisted dot-dot entry",
+			repo:    func(string) RepoExport { return RepoExport{ID: "r"} },
+			entries: []testEntry{{name: "files/r/../../../evil", data: "pwn"}},
+		},
+		{
+			name: "absolute file path",
+			repo: func(tmp string) RepoExport {
+				return RepoExport{ID: "r", Files: []string{filepath.Join(tmp, "evil")}}
+			},
+		},
+		{
+			name: "non-clean file paths",
+			repo: func(string) RepoExport {
+				return RepoExport{ID: "r", Files: []string{"a/./b", "a//b", "./a", "a/", `..\evil`}}
+			},
+			entries: []testEntry{
+				{name: "files/r/a/./b", data: "pwn"},
+				{name: "files/r/a//b", data: "pwn"},
+				{name: "files/r/./a", data: "pwn"},
+				{name: `files/r/..\evil`, data: "pwn"},
+			},
+		},
+		{
+			name:    "relative repository path",
+			repo:    func(string) RepoExport { return RepoExport{ID: "r", Path: "../outside", Files: []string{".env"}} },
+			entries: []testEntry{{name: "files/r/.env", data: "pwn"}},
+		},
+		{
+			name: "non-clean repository path",
+			repo: func(tmp string) RepoExport {
+				return RepoExport{ID: "r", Path: tmp + "/a/../outside", Files: []string{".env"}}
+			},
+			entries: []testEntry{{name: "files/r/.env", data: "pwn"}},
+		},
+		{
+			name:    "rel_path escapes",
+			repo:    func(string) RepoExport { return RepoExport{ID: "r", RelPath: "../../outside", Files: []string{".env"}} },
+			entries: []testEntry{{name: "files/r/.env", data: "pwn"}},
+		},
+		{
+			name: "absolute rel_path",
+			repo: func(tmp string) RepoExport {
+				return RepoExport{ID: "r", RelPath: filepath.Join(tmp, "outside"), Files: []string{".env"}}
+			},
+			entries: []testEntry{{name: "files/r/.env", data: "pwn"}},
+		},
+		{
+			name: "home_path escapes",
+			repo: func(string) RepoExport {
+				return RepoExport{ID: "r", HomePath: "../../outside", Files: []string{".env"}}
+			},
+			entries: []testEntry{{name: "files/r/.env", data: "pwn"}},
+		},
+		{
+			name:    "id with a slash",
+			repo:    func(string) RepoExport { return RepoExport{ID: "../../outside", Files: []string{".env"}} },
+			entries: []testEntry{{name: "files/../../outside/.env", data: "pwn"}},
+		},
+		{
+			name:    "dot-dot id",
+			repo:    func(string) RepoExport { return RepoExport{ID: "..", Files: []string{".env"}} },
+			entries: []testEntry{{name: "files/../.env", data: "pwn"}},
+		},
+		{
+			name:    "symlink entry",
+			repo:    func(string) RepoExport { return RepoExport{ID: "r", Files: []string{".env"}} },
+			entries: []testEntry{{name: "files/r/.env", link: "/etc/passwd"}},
+		},
+		{
+			name: "absolute symlink in manifest",
+			repo: func(string) RepoExport {
+				return RepoExport{ID: "r", Files: []string{"evil"}, Attrs: map[string]FileAttrs{"evil": {Link: "/etc/shadow"}}}
+			},
+		},
+		{
+			name: "symlink in manifest leaving the repository",
+			repo: func(string) RepoExport {
+				return RepoExport{ID: "r", Files: []string{"sub/evil"}, Attrs: map[string]FileAttrs{"sub/evil": {Link: "../../outside"}}}
+			},
+		},
+		{
+			name: "symlink in manifest leaving through a symlinked directory",
+			repo: func(string) RepoExport {
+				return RepoExport{ID: "r", Files: []string{"e"}, Attrs: map[string]FileAttrs{"e": {Link: "sub/d/../outside"}}}
+			},
+			setup: func(t *testing.T, tmp, repoRoot string) {
+				if err := os.Mkdir(filepath.Join(repoRoot, "sub"), 0755); err != nil {
+					t.Fatal(err)
+				}
+				mustSymlink(t, "..", filepath.Join(repoRoot, "sub", "d"))
+			},
+		},
+		{
+			name:    "parent directory is a symlink leaving the repository",
+			repo:    func(string) RepoExport { return RepoExport{ID: "r", Files: []string{"link/evil"}} },
+			entries: []testEntry{{name: "files/r/link/evil", data: "pwn"}},
+			setup: func(t *testing.T, tmp, repoRoot string) {
+				mustSymlink(t, filepath.Join(tmp, "outside"), filepath.Join(repoRoot, "link"))
+			},
+		},
+		{
+			name:    "destination is a symlink",
+			repo:    func(string) RepoExport { return RepoExport{ID: "r", Files: []string{".env"}} },
+			entries: []testEntry{{name: "files/r/.env", data: "pwn"}},
+			setup: func(t *testing.T, tmp, repoRoot string) {
+				mustSymlink(t, filepath.Join(tmp, "outside", "target"), filepath.Join(repoRoot, ".env"))
+			},
+		},
+	}
+
+	for _, format := range []string{formatZip, formatTarGz} {
+		for _, tt := range tests {
+			t.Run(format+"/"+tt.name, func(t *testing.T) {
+				tmp := t.TempDir()
+				// Deep enough that ../ chains stay inside tmp if they got through
+				base := filepath.Join(tmp, "a", "b", "c", "base")
+				outside := filepath.Join(tmp, "outside")
+				if err := os.MkdirAll(outside, 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(filepath.Join(outside, "target"), []byte("orig"), 0644); err != nil {
+					t.Fatal(err)
+				}
+				if tt.setup != nil {
+					repoRoot := filepath.Join(base, "r")
+					if err := os.MkdirAll(repoRoot, 0755); err != nil {
+						t.Fatal(err)
+					}
+					tt.setup(t, tmp, repoRoot)
+				}
+
+				before := snapshot(t, tmp)
+				data := buildArchive(t, format, testManifest(tt.repo(tmp)), tt.entries)
+				summary, output := importTestArchive(t, base, data)
+
+				if !reflect.DeepEqual(snapshot(t, tmp), before) {
+					t.Errorf("import changed the file system:\n%s", output)
+				}
+				if n := len(summary.Created) + len(summary.Replaced); n > 0 {
+					t.Errorf("%d files written", n)
+				}
+				if !strings.Contains(output, "rejected") {
+					t.Errorf("import did not report a rejection:\n%s", output)
+				}
+			})
+		}
+	}
+}
+
+// TestImportWritesSafeArchive checks the harness above does see writes
+func TestImportWritesSafeArchive(t *testing.T) {
+	for _, format := range []string{formatZip, formatTarGz} {
+		t.Run(format, func(t *testing.T) {
+			tmp := t.TempDir()
+			repo := RepoExport{
+				ID:    "r",
+				Files: []string{"sub/.env", ".env"},
+				Attrs: map[string]FileAttrs{".env": {Link: "sub/.env"}},
+			}
+			data := buildArchive(t, format, testManifest(repo), []testEntry{{name: "files/r/sub/.env", data: "KEY=1\n"}})
+			summary, output := importTestArchive(t, tmp, data)
+
+			if len(summary.Created) != 2 {
+				t.Fatalf("created %v, want 2 files:\n%s", summary.Created, output)
+			}
+			got, err := os.ReadFile(filepath.Join(tmp, "r", ".env"))
+			if err != nil || string(got) != "KEY=1\n" {
+				t.Errorf("r/.env = %q, %v", got, err)
+			}
+		})
+	}
+}
+
+// TestImportRejectsLinkThroughArchivedLink checks targets are resolved
+// through symlinks the same archive creates, whatever their order
+func TestImportRejectsLinkThroughArchivedLink(t *testing.T) {
+	for _, format := range []string{formatZip, formatTarGz} {
+		t.Run(format, func(t *testing.T) {
+			base := filepath.Join(t.TempDir(), "base")
+			repo := RepoExport{
+				ID:    "r",
+				Files: []string{"e", "sub/d"},
+				Attrs: map[string]FileAttrs{
+					"e":     {Link: "sub/d/../outside"},
+					"sub/d": {Link: ".."},
+				},
+			}
+			summary, output := importTestArchive(t, base, buildArchive(t, format, testManifest(repo), nil))
+
+			if _, err := os.Lstat(filepath.Join(base, "r", "e")); err == nil {
+				t.Errorf("e was created:\n%s", output)
+			}
+			if !strings.Contains(output, "e: rejected") {
+				t.Errorf("import did not reject e:\n%s", output)
+			}
+			if len(summary.Created) != 1 {
+				t.Errorf("created %v, want only sub/d:\n%s", summary.Created, output)
+			}
+		})
+	}
+}
+
+// TestImportRefusesFileWithoutChecksum checks a file missing from the
+// checksums of an archive that has them is not written
+func TestImportRefusesFileWithoutChecksum(t *testing.T) {
+	tmp := t.TempDir()
+	digest := sha256.Sum256([]byte("KEY=1\n"))
+	repo := RepoExport{
+		ID:        "r",
+		Files:     []string{".env", "added.env"},
+		Checksums: map[string]FileChecksum{".env": {Size: 6, SHA256: hex.EncodeToString(digest[:])}},
+	}
+	data := buildArchive(t, formatZip, testManifest(repo), []testEntry{
+		{name: "files/r/.env", data: "KEY=1\n"},
+		{name: "files/r/added.env", data: "pwn"},
+	})
+	summary, output := importTestArchive(t, tmp, data)
+
+	if len(summary.Created) != 1 || len(summary.Failed) != 1 {
+		t.Errorf("created %v, failed %v; want .env created and added.env failed:\n%s", summary.Created, summary.Failed, output)
+	}
+	if _, err := os.Lstat(filepath.Join(tmp, "r", "added.env")); err == nil {
+		t.Errorf("added.env was written:\n%s", output)
+	}
+}
+
+// TestImportRejectsSharedDestination checks two repositories resolving to
+// the same directory don't both write into it
+func TestImportRejectsSharedDestination(t *testing.T) {
+	tmp := t.TempDir()
+	manifest := testManifest(
+		RepoExport{ID: "work-api", RelPath: "api", Files: []string{".env"}},
+		RepoExport{ID: "oss-api", RelPath: "api", Files: []string{".env"}},
+	)
+	data := buildArchive(t, formatZip, manifest, []testEntry{
+		{name: "files/work-api/.env", data: "WORK=1\n"},
+		{name: "files/oss-api/.env", data: "OSS=1\n"},
+	})
+	summary, output := importTestArchive(t, tmp, data)
+
+	if got, err := os.ReadFile(filepath.Join(tmp, "api", ".env")); err != nil || string(got) != "WORK=1\n" {
+		t.Errorf("api/.env = %q, %v", got, err)
+	}
+	if len(summary.Created)+len(summary.Replaced) != 1 {
+		t.Errorf("created %v, replaced %v; want one file:\n%s", summary.Created, summary.Replaced, output)
+	}
+	if !strings.Contains(output, "already the destination of work-api") {
+		t.Errorf("import did not report the shared destination:\n%s", output)
+	}
+}
+
+func mustSymlink(t *testing.T, target, path string) {
+	t.Helper()
+	if err := os.Symlink(target, path); err != nil {
+		t.Fatal(err)
+	}
+}
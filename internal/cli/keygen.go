@@ -0,0 +1,82 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/O6lvl4/igloc/internal/config"
+	"github.com/O6lvl4/igloc/internal/encrypt"
+	"github.com/spf13/cobra"
+)
+
+var (
+	keygenOutput string
+	keygenForce  bool
+)
+
+// NewKeygenCmd creates the keygen command
+func NewKeygenCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "keygen",
+		Short: "Generate a key pair for encrypted exports",
+		Long: `Generate an X25519 key pair for encrypted export archives.
+
+The private key is saved to ~/.config/igloc/identity.key and is used by
+import to decrypt archives. Share the printed public key and pass it to
+export --recipient on the machine you export from.
+
+If the identity file already exists, its public key is printed instead.
+
+Examples:
+  igloc keygen                          # Create or show your key
+  igloc keygen -o work.key              # Write the key to another file`,
+		Args: cobra.NoArgs,
+		RunE: runKeygen,
+	}
+
+	cmd.Flags().StringVarP(&keygenOutput, "output", "o", "", "Identity file (default: ~/.config/igloc/identity.key)")
+	cmd.Flags().BoolVar(&keygenForce, "force", false, "Overwrite an existing identity file")
+
+	return cmd
+}
+
+func runKeygen(cmd *cobra.Command, args []string) error {
+	path := keygenOutput
+	if path == "" {
+		var err error
+		if path, err = config.IdentityFilePath(); err != nil {
+			return err
+		}
+	}
+
+	if data, err := os.ReadFile(path); err == nil && !keygenForce {
+		keys, err := encrypt.ParseIdentities(data)
+		if err != nil {
+			return fmt.Errorf("invalid identity file %s: %w", path, err)
+		}
+		fmt.Printf("Identity file %s already exists (use --force to replace it)\n", path)
+		for _, key := range keys {
+			fmt.Printf("Public key: %s\n", encrypt.EncodePublicKey(key.PublicKey()))
+		}
+		return nil
+	}
+
+	key, err := encrypt.GenerateIdentity()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	content := fmt.Sprintf("# public key: %s\n%s\n",
+		encrypt.EncodePublicKey(key.PublicKey()), encrypt.EncodeIdentity(key))
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		return fmt.Errorf("failed to write identity: %w", err)
+	}
+
+	fmt.Printf("Wrote identity to %s\n", path)
+	fmt.Printf("Public key: %s\n", encrypt.EncodePublicKey(key.PublicKey()))
+	return nil
+}
@@ -0,0 +1,189 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/O6lvl4/igloc/internal/scanner"
+)
+
+// repoDestination is where an archived repository is restored and why
+type repoDestination struct {
+	Root   string
+	Reason string
+}
+
+// localRepo is a repository found under the import search root
+type localRepo struct {
+	path string
+	id   scanner.RepoIdentity
+}
+
+// repoLocator discovers the repositories under the search root once and
+// matches archived repositories against their remotes and root commits
+type repoLocator struct {
+	root    string
+	scanner *scanner.Scanner
+
+	once  sync.Once
+	repos []localRepo
+	err   error
+}
+
+func newRepoLocator(root string) *repoLocator {
+	if abs, err := filepath.Abs(root); err == nil {
+		root = abs
+	}
+	return &repoLocator{root: root, scanner: scanner.NewScanner()}
+}
+
+func (l *repoLocator) discover() {
+	paths, err := scanner.FindRepos(l.root, scanner.DefaultJobs())
+	if err != nil {
+		l.err = err
+		return
+	}
+	for _, path := range paths {
+		l.repos = append(l.repos, localRepo{path: path, id: l.scanner.Identify(path)})
+	}
+}
+
+// find returns the local clone of an archived repository. When several
+// clones match, the one with the same directory name wins.
+func (l *repoLocator) find(repo RepoExport) (string, error) {
+	l.once.Do(l.discover)
+	if l.err != nil {
+		return "", fmt.Errorf("failed to search %s: %w", l.root, l.err)
+	}
+
+	var matches []string
+	for _, local := range l.repos {
+		if repo.identity().SameRepo(local.id) {
+			matches = append(matches, local.path)
+		}
+	}
+	for _, path := range matches {
+		if filepath.Base(path) == repo.Name {
+			return path, nil
+		}
+	}
+	if len(matches) > 0 {
+		return matches[0], nil
+	}
+	return "", nil
+}
+
+var (
+	// importLocator is created on first use with the --search root
+	importLocator *repoLocator
+
+	// importDestinations caches the destination of each archived repository
+	// by ID
+	importDestinations = make(map[string]repoDestination)
+)
+
+// locateRepo decides where an archived repository is restored:
+//  1. --base joined with the path relative to the export scan root
+//  2. the original path, if it is still a clone of the same repository
+//  3. the same path relative to $HOME, if it is a clone of the repository
+//  4. a clone under the --search root with a matching remote or root commit
+//  5. ./<id>
+//
+// A destination is given to one repository only: archived repositories
+// with the same remote can match the same clone, and the first one in the
+// manifest gets it.
+func locateRepo(repo RepoExport) (repoDestination, error) {
+	if dest, ok := importDestinations[repo.ID]; ok {
+		return dest, nil
+	}
+
+	dest, err := findRepoDestination(repo)
+	if err != nil {
+		return repoDestination{}, err
+	}
+	for id, other := range importDestinations {
+		if other.Root == dest.Root {
+			return repoDestination{}, fmt.Errorf("%s is already the destination of %s (import one of them at a time with --only)", dest.Root, id)
+		}
+	}
+	importDestinations[repo.ID] = dest
+	return dest, nil
+}
+
+func findRepoDestination(repo RepoExport) (repoDestination, error) {
+	if err := validateRepoID(repo.ID); err != nil {
+		return repoDestination{}, err
+	}
+	if repo.Path != "" && (!filepath.IsAbs(repo.Path) || filepath.Clean(repo.Path) != repo.Path) {
+		return repoDestination{}, fmt.Errorf("unsafe repository path %q in manifest", repo.Path)
+	}
+	for _, rel := range []string{repo.RelPath, repo.HomePath} {
+		if rel != "" && rel != "." {
+			if err := validateEntryPath(rel); err != nil {
+				return repoDestination{}, fmt.Errorf("unsafe relative path %q in manifest: %w", rel, err)
+			}
+		}
+	}
+
+	if importBaseDir != "" {
+		// Rebase the layout below the export scan root onto --base
+		rel := repo.ID
+		if repo.RelPath != "" && repo.RelPath != "." {
+			rel = filepath.FromSlash(repo.RelPath)
+		}
+		// Undo records and journals outlive the working directory, so
+		// destinations are always absolute
+		base, err := filepath.Abs(importBaseDir)
+		if err != nil {
+			return repoDestination{}, err
+		}
+		return repoDestination{Root: filepath.Join(base, rel), Reason: "--base"}, nil
+	}
+
+	// Only restore into existing clones of the repository, so a manifest
+	// cannot point files at arbitrary directories
+	if repo.Path != "" && isCloneOf(repo.Path, repo) {
+		return repoDestination{Root: repo.Path, Reason: "original path"}, nil
+	}
+
+	if repo.HomePath != "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			path := filepath.Join(home, filepath.FromSlash(repo.HomePath))
+			if isCloneOf(path, repo) {
+				return repoDestination{Root: path, Reason: "home-relative path"}, nil
+			}
+		}
+	}
+
+	if !repo.identity().IsZero() {
+		if importLocator == nil {
+			importLocator = newRepoLocator(importSearch)
+		}
+		path, err := importLocator.find(repo)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		}
+		if path != "" {
+			return repoDestination{Root: path, Reason: "matched clone"}, nil
+		}
+	}
+
+	// Fall back to current directory + repo ID
+	root, err := filepath.Abs(repo.ID)
+	if err != nil {
+		return repoDestination{}, err
+	}
+	return repoDestination{Root: root, Reason: "new directory"}, nil
+}
+
+// isCloneOf reports whether path is a git repository and, when the archive
+// recorded remotes or a root commit, a clone of the same repository
+func isCloneOf(path string, repo RepoExport) bool {
+	if !dirExists(path) || !fileExists(filepath.Join(path, ".git")) {
+		return false
+	}
+	id := repo.identity()
+	return id.IsZero() || id.SameRepo(scanner.NewScanner().Identify(path))
+}
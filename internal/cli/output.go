@@ -0,0 +1,124 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/O6lvl4/igloc/internal/scanner"
+)
+
+// Output formats for scan
+const (
+	formatText   = "text"
+	formatJSON   = "json"
+	formatNDJSON = "ndjson"
+	formatSARIF  = "sarif"
+)
+
+// resultWriter renders scan results in a machine-readable format
+type resultWriter interface {
+	Add(result *scanner.ScanResult) error
+	Close() error
+}
+
+// newResultWriter returns the writer for a format, or nil for text output
+func newResultWriter(w io.Writer, format, version string) (resultWriter, error) {
+	switch format {
+	case formatText:
+		return nil, nil
+	case formatJSON:
+		return newJSONWriter(w, false), nil
+	case formatNDJSON:
+		return newJSONWriter(w, true), nil
+	case formatSARIF:
+		return newSARIFWriter(w, version), nil
+	default:
+		return nil, fmt.Errorf("invalid --format value: %s (use text, json, ndjson or sarif)", format)
+	}
+}
+
+// jsonSchemaVersion is bumped whenever the JSON output changes incompatibly
+const jsonSchemaVersion = 1
+
+// jsonReport is the document written by --format json
+type jsonReport struct {
+	SchemaVersion int                   `json:"schema_version"`
+	Results       []*scanner.ScanResult `json:"results"`
+	Summary       scanSummary           `json:"summary"`
+}
+
+// jsonRecord is one line written by --format ndjson
+type jsonRecord struct {
+	SchemaVersion int                 `json:"schema_version"`
+	Type          string              `json:"type"` // "result" or "summary"
+	Result        *scanner.ScanResult `json:"result,omitempty"`
+	Summary       *scanSummary        `json:"summary,omitempty"`
+}
+
+// scanSummary totals the repositories that had ignored files
+type scanSummary struct {
+	Repositories int   `json:"repositories"`
+	Files        int   `json:"files"`
+	Secrets      int   `json:"secrets"`
+	TotalSize    int64 `json:"total_size"`
+}
+
+// jsonWriter writes scan results as a JSON document, or as one JSON object
+// per line when streaming
+type jsonWriter struct {
+	enc     *json.Encoder
+	stream  bool
+	results []*scanner.ScanResult
+	summary scanSummary
+}
+
+func newJSONWriter(w io.Writer, stream bool) *jsonWriter {
+	enc := json.NewEncoder(w)
+	if !stream {
+		enc.SetIndent("", "  ")
+	}
+	return &jsonWriter{
+		enc:     enc,
+		stream:  stream,
+		results: []*scanner.ScanResult{},
+	}
+}
+
+// Add records a result, writing it immediately when streaming
+func (j *jsonWriter) Add(result *scanner.ScanResult) error {
+	if len(result.IgnoredFiles) > 0 {
+		j.summary.Repositories++
+		j.summary.Files += len(result.IgnoredFiles)
+		j.summary.Secrets += result.SecretCount
+		j.summary.TotalSize += result.TotalSize
+	}
+
+	if j.stream {
+		return j.enc.Encode(jsonRecord{
+			SchemaVersion: jsonSchemaVersion,
+			Type:          "result",
+			Result:        result,
+		})
+	}
+
+	j.results = append(j.results, result)
+	return nil
+}
+
+// Close writes the summary, or the whole document when not streaming
+func (j *jsonWriter) Close() error {
+	if j.stream {
+		return j.enc.Encode(jsonRecord{
+			SchemaVersion: jsonSchemaVersion,
+			Type:          "summary",
+			Summary:       &j.summary,
+		})
+	}
+
+	return j.enc.Encode(jsonReport{
+		SchemaVersion: jsonSchemaVersion,
+		Results:       j.results,
+		Summary:       j.summary,
+	})
+}
@@ -0,0 +1,284 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"sort"
+
+	"github.com/O6lvl4/igloc/internal/scanner"
+)
+
+const (
+	sarifVersion = "2.1.0"
+	sarifSchema  = "https://json.schemastore.org/sarif-2.1.0.json"
+)
+
+// SARIF 2.1.0 document types, limited to the properties igloc fills in
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool               sarifTool                   `json:"tool"`
+	OriginalURIBaseIDs map[string]sarifArtifactLoc `json:"originalUriBaseIds,omitempty"`
+	Results            []sarifResult               `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	Version        string      `json:"version,omitempty"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID                   string        `json:"id"`
+	Name                 string        `json:"name"`
+	ShortDescription     sarifMessage  `json:"shortDescription"`
+	DefaultConfiguration sarifRuleConf `json:"defaultConfiguration"`
+}
+
+type sarifRuleConf struct {
+	Level string `json:"level"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	Level     string          `json:"level"`
+	Rank      float64         `json:"rank"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLoc `json:"physicalLocation"`
+}
+
+type sarifPhysicalLoc struct {
+	ArtifactLocation sarifArtifactLoc `json:"artifactLocation"`
+	Region           *sarifRegion     `json:"region,omitempty"`
+}
+
+type sarifArtifactLoc struct {
+	URI       string `json:"uri"`
+	URIBaseID string `json:"uriBaseId,omitempty"`
+}
+
+type sarifRegion struct {
+	StartLine int `json:"startLine"`
+}
+
+// sarifWriter collects scan results into a single SARIF run
+type sarifWriter struct {
+	w       io.Writer
+	version string
+	run     sarifRun
+	rules   map[string]sarifRule
+}
+
+func newSARIFWriter(w io.Writer, version string) *sarifWriter {
+	return &sarifWriter{
+		w:       w,
+		version: version,
+		run: sarifRun{
+			OriginalURIBaseIDs: make(map[string]sarifArtifactLoc),
+			Results:            []sarifResult{},
+		},
+		rules: make(map[string]sarifRule),
+	}
+}
+
+// Add converts the secret files of a result into SARIF results
+func (sw *sarifWriter) Add(result *scanner.ScanResult) error {
+	// Each repository gets its own base so locations stay relative to it
+	baseID := "SRCROOT"
+	if n := len(sw.run.OriginalURIBaseIDs); n > 0 {
+		baseID = fmt.Sprintf("SRCROOT%d", n)
+	}
+	sw.run.OriginalURIBaseIDs[baseID] = sarifArtifactLoc{
+		URI: (&url.URL{Scheme: "file", Path: filepath.ToSlash(result.RootPath) + "/"}).String(),
+	}
+
+	for _, f := range result.IgnoredFiles {
+		if !f.IsSecret {
+			continue
+		}
+
+		artifact := sarifArtifactLoc{
+			URI:       (&url.URL{Path: f.Path}).String(),
+			URIBaseID: baseID,
+		}
+
+		ruleID := sw.categoryRule(f.Category)
+		message := fmt.Sprintf("Ignored %s file %s likely contains secrets", f.Category, f.Path)
+		if f.Exposed {
+			ruleID = sw.exposedRule(f.Category)
+			message = fmt.Sprintf("Tracked %s file %s likely contains secrets; run: %s", f.Category, f.Path, f.FixCommand)
+		}
+		if f.History != nil {
+			ruleID = sw.historyRule(f.Category)
+			message = fmt.Sprintf("%s file %s was committed in %s on %s", f.Category, f.Path,
+				f.History.Commit, f.History.AuthorDate.Format("2006-01-02"))
+		}
+		sw.run.Results = append(sw.run.Results, sarifResult{
+			RuleID:  ruleID,
+			Level:   sw.rules[ruleID].DefaultConfiguration.Level,
+			Rank:    f.Confidence * 100,
+			Message: sarifMessage{Text: message},
+			Locations: []sarifLocation{
+				{PhysicalLocation: sarifPhysicalLoc{ArtifactLocation: artifact}},
+			},
+		})
+
+		for _, finding := range f.Findings {
+			ruleID := sw.contentRule(finding.RuleID)
+			rule := sw.rules[ruleID]
+			sw.run.Results = append(sw.run.Results, sarifResult{
+				RuleID:  ruleID,
+				Level:   rule.DefaultConfiguration.Level,
+				Rank:    f.Confidence * 100,
+				Message: sarifMessage{Text: fmt.Sprintf("%s in %s", rule.ShortDescription.Text, f.Path)},
+				Locations: []sarifLocation{
+					{PhysicalLocation: sarifPhysicalLoc{
+						ArtifactLocation: artifact,
+						Region:           &sarifRegion{StartLine: finding.Line},
+					}},
+				},
+			})
+		}
+	}
+
+	return nil
+}
+
+// Close writes the SARIF document
+func (sw *sarifWriter) Close() error {
+	var ids []string
+	for id := range sw.rules {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	driver := sarifDriver{
+		Name:           "igloc",
+		Version:        sw.version,
+		InformationURI: "https://github.com/O6lvl4/igloc",
+		Rules:          []sarifRule{},
+	}
+	for _, id := range ids {
+		driver.Rules = append(driver.Rules, sw.rules[id])
+	}
+	sw.run.Tool = sarifTool{Driver: driver}
+
+	enc := json.NewEncoder(sw.w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(sarifLog{
+		Schema:  sarifSchema,
+		Version: sarifVersion,
+		Runs:    []sarifRun{sw.run},
+	})
+}
+
+// categoryRule registers the rule for secret files of a category
+func (sw *sarifWriter) categoryRule(category string) string {
+	id := "secret-file/" + category
+	if _, ok := sw.rules[id]; !ok {
+		sw.rules[id] = sarifRule{
+			ID:                   id,
+			Name:                 "SecretFile",
+			ShortDescription:     sarifMessage{Text: fmt.Sprintf("Ignored %s file that likely contains secrets", category)},
+			DefaultConfiguration: sarifRuleConf{Level: categoryLevel(category)},
+		}
+	}
+	return id
+}
+
+// exposedRule registers the rule for tracked secret files of a category.
+// A committed secret is always an error.
+func (sw *sarifWriter) exposedRule(category string) string {
+	id := "exposed-file/" + category
+	if _, ok := sw.rules[id]; !ok {
+		sw.rules[id] = sarifRule{
+			ID:                   id,
+			Name:                 "ExposedSecretFile",
+			ShortDescription:     sarifMessage{Text: fmt.Sprintf("Tracked %s file that likely contains secrets", category)},
+			DefaultConfiguration: sarifRuleConf{Level: "error"},
+		}
+	}
+	return id
+}
+
+// historyRule registers the rule for secret files found in past commits
+func (sw *sarifWriter) historyRule(category string) string {
+	id := "history-file/" + category
+	if _, ok := sw.rules[id]; !ok {
+		sw.rules[id] = sarifRule{
+			ID:                   id,
+			Name:                 "SecretFileInHistory",
+			ShortDescription:     sarifMessage{Text: fmt.Sprintf("%s file that likely contains secrets in git history", category)},
+			DefaultConfiguration: sarifRuleConf{Level: "error"},
+		}
+	}
+	return id
+}
+
+// contentRule registers the rule for a content finding
+func (sw *sarifWriter) contentRule(ruleID string) string {
+	id := "content/" + ruleID
+	if _, ok := sw.rules[id]; !ok {
+		desc := ruleID
+		switch ruleID {
+		case scanner.RuleHighEntropyBase64:
+			desc = "High-entropy base64 value"
+		case scanner.RuleHighEntropyHex:
+			desc = "High-entropy hex value"
+		default:
+			for _, rule := range scanner.ContentRules() {
+				if rule.ID == ruleID {
+					desc = rule.Description
+				}
+			}
+		}
+		sw.rules[id] = sarifRule{
+			ID:                   id,
+			Name:                 "SecretContent",
+			ShortDescription:     sarifMessage{Text: desc},
+			DefaultConfiguration: sarifRuleConf{Level: contentLevel(ruleID)},
+		}
+	}
+	return id
+}
+
+// contentLevel maps a content finding to a SARIF level; known credential
+// formats are errors, entropy matches only warnings
+func contentLevel(ruleID string) string {
+	if ruleID == scanner.RuleHighEntropyBase64 || ruleID == scanner.RuleHighEntropyHex {
+		return "warning"
+	}
+	return "error"
+}
+
+// categoryLevel maps a secret file category to a SARIF level
+func categoryLevel(category string) string {
+	switch category {
+	case "key":
+		return "error"
+	case "env":
+		return "warning"
+	default:
+		return "note"
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/O6lvl4/igloc/internal/scanner"
@@ -16,6 +17,16 @@ var (
 	flagRecursive   bool
 	flagCategory    string
 	flagIncludeDeps bool
+	flagNoContent   bool
+	flagNoEntropy   bool
+	flagBase64      float64
+	flagHex         float64
+	flagSort        string
+	flagBackend     string
+	flagJobs        int
+	flagFormat      string
+	flagExposed     bool
+	flagHistory     bool
 )
 
 // NewScanCmd creates the scan command
@@ -33,14 +44,28 @@ Examples:
   igloc scan ~/projects         # Scan specific directory
   igloc scan -r ~/projects      # Recursively scan all git repos
   igloc scan --all              # Show all ignored files, not just secrets
-  igloc scan --category env     # Show only .env files`,
+  igloc scan --category env     # Show only .env files
+  igloc scan --exposed          # Find committed secret files
+  igloc scan --history          # Find secret files in past commits
+  igloc scan -r --format ndjson # Stream one JSON object per repository
+  igloc scan --format sarif     # SARIF 2.1.0 for code scanning tools`,
 		RunE: runScan,
 	}
 
 	cmd.Flags().BoolVarP(&flagAll, "all", "a", false, "Show all ignored files, not just secrets")
 	cmd.Flags().BoolVarP(&flagRecursive, "recursive", "r", false, "Recursively scan subdirectories for git repos")
-	cmd.Flags().StringVarP(&flagCategory, "category", "c", "", "Filter by category (env, key, config, build, cache, ide, other)")
+	cmd.Flags().StringVarP(&flagCategory, "category", "c", "", "Filter by category (env, key, config, build, cache, ide, other, or a custom one from rules.yaml)")
 	cmd.Flags().BoolVar(&flagIncludeDeps, "include-deps", false, "Include files in node_modules, vendor, etc.")
+	cmd.Flags().BoolVar(&flagNoContent, "no-content", false, "Don't inspect file contents for credentials")
+	cmd.Flags().BoolVar(&flagNoEntropy, "no-entropy", false, "Don't flag high-entropy values")
+	cmd.Flags().Float64Var(&flagBase64, "entropy-base64", scanner.DefaultEntropyOptions().Base64Threshold, "Entropy threshold for base64-like values (bits per char)")
+	cmd.Flags().Float64Var(&flagHex, "entropy-hex", scanner.DefaultEntropyOptions().HexThreshold, "Entropy threshold for hex values (bits per char)")
+	cmd.Flags().StringVar(&flagSort, "sort", "path", "Order files within a category (path, confidence)")
+	cmd.Flags().BoolVar(&flagExposed, "exposed", false, "Report tracked (committed) files that look like secrets")
+	cmd.Flags().BoolVar(&flagHistory, "history", false, "Report secret files found anywhere in git history")
+	cmd.Flags().StringVarP(&flagFormat, "format", "f", formatText, "Output format (text, json, ndjson, sarif)")
+	cmd.Flags().IntVarP(&flagJobs, "jobs", "j", scanner.DefaultJobs(), "Number of repositories to scan in parallel with -r")
+	cmd.Flags().StringVar(&flagBackend, "backend", "auto", "How to find ignored files (auto, git, native)")
 
 	return cmd
 }
@@ -61,80 +86,115 @@ func runScan(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("path does not exist: %s", absPath)
 	}
 
-	s := scanner.NewScanner()
+	s, err := newScanner()
+	if err != nil {
+		return err
+	}
 	s.ShowAll = flagAll
+	s.Exposed = flagExposed
+	s.History = flagHistory
+	if flagExposed && flagHistory {
+		return fmt.Errorf("--exposed and --history can't be used together")
+	}
 	s.ExcludeDeps = !flagIncludeDeps
+	s.InspectContent = !flagNoContent
+	s.DetectEntropy = !flagNoEntropy
+	s.Entropy.Base64Threshold = flagBase64
+	s.Entropy.HexThreshold = flagHex
+	if s.Backend, err = scanner.ParseBackend(flagBackend); err != nil {
+		return err
+	}
+
+	out, err := newResultWriter(os.Stdout, flagFormat, cmd.Root().Version)
+	if err != nil {
+		return err
+	}
+
+	if flagSort != "path" && flagSort != "confidence" {
+		return fmt.Errorf("invalid --sort value: %s (use path or confidence)", flagSort)
+	}
+
+	if flagCategory != "" {
+		if !s.Rules.HasCategory(flagCategory) {
+			return fmt.Errorf("unknown category: %s (available: %s)",
+				flagCategory, strings.Join(s.Rules.Categories(), ", "))
+		}
+		s.Categories = []string{flagCategory}
+	}
 
 	if flagRecursive {
-		return scanRecursive(s, absPath)
+		return scanRecursive(s, absPath, out)
 	}
 
-	return scanSingle(s, absPath)
+	return scanSingle(s, absPath, out)
 }
 
-func scanSingle(s *scanner.Scanner, path string) error {
+// scanSingle scans one directory; out is nil for text output
+func scanSingle(s *scanner.Scanner, path string, out resultWriter) error {
 	result, err := s.Scan(path)
 	if err != nil {
 		return fmt.Errorf("scan failed: %w", err)
 	}
 
-	printResult(result)
+	if out != nil {
+		if err := out.Add(result); err != nil {
+			return err
+		}
+		return out.Close()
+	}
+
+	printResult(result, s.Rules)
 	return nil
 }
 
-func scanRecursive(s *scanner.Scanner, rootPath string) error {
-	var allResults []*scanner.ScanResult
+// scanRecursive scans every repository below rootPath; out is nil for text output
+func scanRecursive(s *scanner.Scanner, rootPath string, out resultWriter) error {
+	var repoCount int
 	var totalSecrets int
 	var totalFiles int
 
-	err := filepath.Walk(rootPath, func(path string, info os.FileInfo, err error) error {
+	var writeErr error
+
+	// Results arrive in path order while later repos are still scanning
+	err := s.ScanRecursive(rootPath, flagJobs, func(result *scanner.ScanResult, err error) {
 		if err != nil {
-			return nil // skip errors
+			fmt.Fprintf(os.Stderr, "Warning: could not scan %v\n", err)
+			return
 		}
-
-		// Check for .git directory
-		if info.IsDir() && info.Name() == ".git" {
-			repoPath := filepath.Dir(path)
-			result, err := s.Scan(repoPath)
-			if err == nil && len(result.IgnoredFiles) > 0 {
-				allResults = append(allResults, result)
-				totalSecrets += result.SecretCount
-				totalFiles += len(result.IgnoredFiles)
-			}
-			return filepath.SkipDir // don't descend into .git
+		if len(result.IgnoredFiles) == 0 {
+			return
 		}
-
-		// Skip common non-repo directories
-		if info.IsDir() {
-			skipDirs := []string{"node_modules", "vendor", ".cache", "__pycache__"}
-			for _, skip := range skipDirs {
-				if info.Name() == skip {
-					return filepath.SkipDir
-				}
+		if out != nil {
+			if writeErr == nil {
+				writeErr = out.Add(result)
 			}
+			return
 		}
-
-		return nil
+		printResult(result, s.Rules)
+		fmt.Println()
+		repoCount++
+		totalSecrets += result.SecretCount
+		totalFiles += len(result.IgnoredFiles)
 	})
-
 	if err != nil {
 		return err
 	}
 
-	if len(allResults) == 0 {
-		fmt.Println("No git repositories found with ignored files.")
-		return nil
+	if out != nil {
+		if writeErr != nil {
+			return writeErr
+		}
+		return out.Close()
 	}
 
-	// Print results for each repo
-	for _, result := range allResults {
-		printResult(result)
-		fmt.Println()
+	if repoCount == 0 {
+		fmt.Println("No git repositories found with ignored files.")
+		return nil
 	}
 
 	// Print summary
 	fmt.Println("========================================")
-	fmt.Printf("Summary: %d repositories, %d files", len(allResults), totalFiles)
+	fmt.Printf("Summary: %d repositories, %d files", repoCount, totalFiles)
 	if totalSecrets > 0 {
 		fmt.Printf(", %d secrets", totalSecrets)
 	}
@@ -143,28 +203,27 @@ func scanRecursive(s *scanner.Scanner, rootPath string) error {
 	return nil
 }
 
-func printResult(result *scanner.ScanResult) {
-	if len(result.IgnoredFiles) == 0 {
-		fmt.Printf("📂 %s\n", result.RootPath)
-		fmt.Println("   No ignored files found.")
-		return
+// newScanner creates a scanner using the classification rules from rules.yaml
+func newScanner() (*scanner.Scanner, error) {
+	rules, err := scanner.LoadRuleset()
+	if err != nil {
+		return nil, err
 	}
 
-	// Filter by category if specified
-	files := result.IgnoredFiles
-	if flagCategory != "" {
-		var filtered []scanner.IgnoredFile
-		for _, f := range files {
-			if f.Category == flagCategory {
-				filtered = append(filtered, f)
-			}
-		}
-		files = filtered
-	}
+	s := scanner.NewScanner()
+	s.Rules = rules
+	return s, nil
+}
 
+func printResult(result *scanner.ScanResult, rules *scanner.Ruleset) {
+	files := result.IgnoredFiles
 	if len(files) == 0 {
 		fmt.Printf("📂 %s\n", result.RootPath)
-		fmt.Printf("   No files found in category: %s\n", flagCategory)
+		if flagCategory != "" {
+			fmt.Printf("   No files found in category: %s\n", flagCategory)
+		} else {
+			fmt.Println("   No ignored files found.")
+		}
 		return
 	}
 
@@ -185,15 +244,34 @@ func printResult(result *scanner.ScanResult) {
 
 	for _, cat := range categories {
 		catFiles := byCategory[cat]
-		icon := getCategoryIcon(cat)
+		if flagSort == "confidence" {
+			sort.SliceStable(catFiles, func(i, j int) bool {
+				return catFiles[i].Confidence > catFiles[j].Confidence
+			})
+		}
+		icon := rules.Icon(cat)
 		fmt.Printf("\n   %s %s (%d)\n", icon, strings.ToUpper(cat), len(catFiles))
 
 		for _, f := range catFiles {
 			secretMark := ""
 			if f.IsSecret {
-				secretMark = " 🔐"
+				secretMark = fmt.Sprintf(" 🔐 %.0f%%", f.Confidence*100)
 			}
 			fmt.Printf("      %s%s\n", f.Path, secretMark)
+			for _, reason := range formatFindings(f.Findings) {
+				fmt.Printf("         ↳ %s\n", reason)
+			}
+			if h := f.History; h != nil {
+				state := "no longer ignored"
+				if h.StillIgnored {
+					state = "ignored now"
+				}
+				fmt.Printf("         ↳ added in %s on %s, %s\n", h.Commit[:7], h.AuthorDate.Format("2006-01-02"), state)
+			}
+			if f.Exposed {
+				fmt.Printf("         ⚠️  tracked by git, add to .gitignore: %s\n", f.GitignoreLine)
+				fmt.Printf("         ⚠️  then stop tracking it: %s\n", f.FixCommand)
+			}
 		}
 	}
 
@@ -204,20 +282,48 @@ func printResult(result *scanner.ScanResult) {
 	fmt.Println()
 }
 
-func getCategoryIcon(category string) string {
-	icons := map[string]string{
-		"env":    "🔑",
-		"key":    "🔐",
-		"config": "⚙️",
-		"build":  "📦",
-		"cache":  "💾",
-		"ide":    "🖥️",
-		"other":  "📄",
+// formatFindings describes content matches as "rule (line N, M)", one per rule
+func formatFindings(findings []scanner.Finding) []string {
+	var ruleIDs []string
+	lines := make(map[string][]string)
+	for _, f := range findings {
+		if _, ok := lines[f.RuleID]; !ok {
+			ruleIDs = append(ruleIDs, f.RuleID)
+		}
+		lines[f.RuleID] = append(lines[f.RuleID], fmt.Sprint(f.Line))
+	}
+
+	var result []string
+	for _, id := range ruleIDs {
+		label := "line"
+		if len(lines[id]) > 1 {
+			label = "lines"
+		}
+		result = append(result, fmt.Sprintf("%s (%s %s)", id, label, strings.Join(lines[id], ", ")))
 	}
-	if icon, ok := icons[category]; ok {
-		return icon
+	return result
+}
+
+// parseSize parses a size such as "512", "100K", "10MB" or "1.5G"
+func parseSize(size string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(size))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "B"), "I")
+
+	mult := int64(1)
+	if n := len(s); n > 0 {
+		if exp := strings.IndexByte("KMGT", s[n-1]); exp >= 0 {
+			for i := 0; i <= exp; i++ {
+				mult *= 1024
+			}
+			s = s[:n-1]
+		}
+	}
+
+	v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid size: %q", size)
 	}
-	return "📄"
+	return int64(v * float64(mult)), nil
 }
 
 func formatSize(bytes int64) string {
@@ -0,0 +1,298 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/O6lvl4/igloc/internal/scanner"
+	"golang.org/x/term"
+)
+
+// importSelection is the set of archived files to import, by repository
+// ID and file path. A nil selection imports everything.
+type importSelection map[string]map[string]bool
+
+func (s importSelection) has(repoID, file string) bool {
+	if s == nil {
+		return true
+	}
+	return s[repoID][file]
+}
+
+func (s importSelection) add(repoID, file string) {
+	if s[repoID] == nil {
+		s[repoID] = make(map[string]bool)
+	}
+	s[repoID][file] = true
+}
+
+// onlyFilter is one --only value: a glob for repository IDs and, after a
+// colon, an optional glob for files within them
+type onlyFilter struct {
+	repo  *scanner.GlobSet
+	files *scanner.GlobSet // nil matches every file
+}
+
+func parseOnlyFilters(specs []string) ([]onlyFilter, error) {
+	var filters []onlyFilter
+	for _, spec := range specs {
+		repo, files, hasFiles := strings.Cut(spec, ":")
+		if repo == "" || hasFiles && files == "" {
+			return nil, fmt.Errorf("invalid --only value %q (use repo or repo:glob)", spec)
+		}
+
+		var f onlyFilter
+		var err error
+		if f.repo, err = scanner.NewGlobSet(repo); err != nil {
+			return nil, fmt.Errorf("invalid --only value %q: %w", spec, err)
+		}
+		if hasFiles {
+			if f.files, err = scanner.NewGlobSet(files); err != nil {
+				return nil, fmt.Errorf("invalid --only value %q: %w", spec, err)
+			}
+		}
+		filters = append(filters, f)
+	}
+	return filters, nil
+}
+
+// selectOnly returns the files of the manifest matched by any filter, or
+// nil to import everything when there are no filters
+func selectOnly(manifest *Manifest, filters []onlyFilter) importSelection {
+	if len(filters) == 0 {
+		return nil
+	}
+
+	selection := make(importSelection)
+	for _, repo := range manifest.Repos {
+		for _, f := range filters {
+			if !f.repo.Match(repo.ID) {
+				continue
+			}
+			for _, file := range repo.Files {
+				if f.files == nil || f.files.Match(file) {
+					selection.add(repo.ID, file)
+				}
+			}
+		}
+	}
+	return selection
+}
+
+// selectItem is a line of the selection list: a repository, or one of
+// its files when file is set
+type selectItem struct {
+	repo     string
+	file     string
+	label    string
+	selected bool
+}
+
+// Keys understood by the selection list
+const (
+	keyUp     = "up"
+	keyDown   = "down"
+	keyToggle = "toggle"
+	keyAll    = "all"
+	keyEnter  = "enter"
+	keyQuit   = "quit"
+)
+
+// selectInteractively shows a checkbox list of the files, grouped by
+// repository like the preview, and returns the ones left checked. It
+// returns false if the user cancelled.
+func selectInteractively(items []*selectItem) (importSelection, bool, error) {
+	in, out := int(os.Stdin.Fd()), int(os.Stdout.Fd())
+	if !term.IsTerminal(in) || !term.IsTerminal(out) {
+		return nil, false, fmt.Errorf("--select needs a terminal; use --only to choose files non-interactively")
+	}
+
+	state, err := term.MakeRaw(in)
+	if err != nil {
+		return nil, false, err
+	}
+	defer term.Restore(in, state)
+
+	// Draw on the alternate screen so the preview is still there afterwards
+	fmt.Print("\x1b[?1049h\x1b[?25l")
+	defer fmt.Print("\x1b[?25h\x1b[?1049l")
+
+	cursor, top := 0, 0
+	for {
+		_, height, err := term.GetSize(out)
+		if err != nil || height < 6 {
+			height = 24
+		}
+		rows := height - 4
+		if cursor < top {
+			top = cursor
+		}
+		if cursor >= top+rows {
+			top = cursor - rows + 1
+		}
+		renderSelection(items, cursor, top, rows)
+
+		key, err := readSelectKey()
+		if err != nil {
+			return nil, false, err
+		}
+		switch key {
+		case keyUp:
+			if cursor > 0 {
+				cursor--
+			}
+		case keyDown:
+			if cursor < len(items)-1 {
+				cursor++
+			}
+		case keyToggle:
+			toggleItem(items, cursor)
+		case keyAll:
+			all := countSelected(items) < countFiles(items)
+			for _, item := range items {
+				item.selected = all
+			}
+		case keyEnter:
+			selection := make(importSelection)
+			for _, item := range items {
+				if item.file != "" && item.selected {
+					selection.add(item.repo, item.file)
+				}
+			}
+			return selection, true, nil
+		case keyQuit:
+			return nil, false, nil
+		}
+	}
+}
+
+// toggleItem flips a file, or every file of a repository
+func toggleItem(items []*selectItem, i int) {
+	if items[i].file != "" {
+		items[i].selected = !items[i].selected
+		return
+	}
+
+	all := true
+	for _, item := range items {
+		if item.repo == items[i].repo && item.file != "" && !item.selected {
+			all = false
+		}
+	}
+	for _, item := range items {
+		if item.repo == items[i].repo {
+			item.selected = !all
+		}
+	}
+}
+
+func renderSelection(items []*selectItem, cursor, top, rows int) {
+	var b strings.Builder
+	b.WriteString("\x1b[H\x1b[2J")
+	b.WriteString("Select files to import (↑/↓ move, space toggle, a all, enter import, q cancel)\r\n\r\n")
+
+	for i := top; i < len(items) && i < top+rows; i++ {
+		item := items[i]
+		pointer := "  "
+		if i == cursor {
+			pointer = "> "
+		}
+		if item.file == "" {
+			fmt.Fprintf(&b, "%s%s %s\r\n", pointer, repoCheckbox(items, item.repo), item.label)
+		} else {
+			fmt.Fprintf(&b, "%s    %s %s\r\n", pointer, checkbox(item.selected), item.label)
+		}
+	}
+
+	fmt.Fprintf(&b, "\r\n%d of %d files selected", countSelected(items), countFiles(items))
+	fmt.Print(b.String())
+}
+
+func checkbox(selected bool) string {
+	if selected {
+		return "[x]"
+	}
+	return "[ ]"
+}
+
+// repoCheckbox shows whether all, some or none of a repository's files
+// are selected
+func repoCheckbox(items []*selectItem, repo string) string {
+	selected, total := 0, 0
+	for _, item := range items {
+		if item.repo == repo && item.file != "" {
+			total++
+			if item.selected {
+				selected++
+			}
+		}
+	}
+	switch selected {
+	case total:
+		return "[x]"
+	case 0:
+		return "[ ]"
+	default:
+		return "[-]"
+	}
+}
+
+func countFiles(items []*selectItem) int {
+	n := 0
+	for _, item := range items {
+		if item.file != "" {
+			n++
+		}
+	}
+	return n
+}
+
+func countSelected(items []*selectItem) int {
+	n := 0
+	for _, item := range items {
+		if item.file != "" && item.selected {
+			n++
+		}
+	}
+	return n
+}
+
+// readSelectKey reads one key press in raw mode
+func readSelectKey() (string, error) {
+	for {
+		c, err := stdinReader.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		switch c {
+		case 'k':
+			return keyUp, nil
+		case 'j':
+			return keyDown, nil
+		case ' ', 'x':
+			return keyToggle, nil
+		case 'a':
+			return keyAll, nil
+		case '\r', '\n':
+			return keyEnter, nil
+		case 'q', 3: // Ctrl-C
+			return keyQuit, nil
+		case 0x1b:
+			// Arrow keys are sent as ESC [ A / ESC [ B
+			if next, err := stdinReader.ReadByte(); err != nil || next != '[' {
+				continue
+			}
+			arrow, err := stdinReader.ReadByte()
+			if err != nil {
+				return "", err
+			}
+			switch arrow {
+			case 'A':
+				return keyUp, nil
+			case 'B':
+				return keyDown, nil
+			}
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// previewSymlink describes what importing an archived symlink will do
+func previewSymlink(repo RepoExport, filePath, target, strategy string) (string, error) {
+	destPath, external, err := resolveSymlink(repo, filePath, target)
+	if err != nil {
+		return "", err
+	}
+
+	status := ""
+	if current, err := os.Readlink(destPath); err == nil && current == target {
+		status = " (identical, skip)"
+	} else if _, err := os.Lstat(destPath); err == nil {
+		status = fmt.Sprintf(" (exists: %s)", strategy)
+	}
+	if external {
+		status += " ⚠ points outside the repository"
+	}
+	return fmt.Sprintf("%s -> %s%s", filePath, target, status), nil
+}
+
+// resolveSymlink returns where an archived symlink is created and whether
+// its target lies outside the repository. Absolute targets are refused, and
+// so are targets outside the repository unless --allow-external-links is set.
+func resolveSymlink(repo RepoExport, filePath, target string) (string, bool, error) {
+	destPath, err := resolveLinkPath(repo, filePath)
+	if err != nil {
+		return "", false, err
+	}
+
+	switch {
+	case target == "":
+		return "", false, fmt.Errorf("empty symlink target")
+	case strings.HasPrefix(target, "/") || filepath.IsAbs(target) || filepath.VolumeName(target) != "":
+		return "", false, fmt.Errorf("symlink target %s is absolute", target)
+	}
+
+	root, err := resolveRepoRoot(repo)
+	if err != nil {
+		return "", false, err
+	}
+	realRoot, err := evalExisting(root)
+	if err != nil {
+		return "", false, err
+	}
+	dir, err := walkLinkTarget(repo, realRoot, realRoot, path.Dir(filePath), 0)
+	if err != nil {
+		return "", false, err
+	}
+	resolved, err := walkLinkTarget(repo, realRoot, dir, target, 0)
+	if err != nil {
+		return "", false, err
+	}
+	inside := isWithin(realRoot, resolved)
+	if !inside && !importAllowExternalLinks {
+		return "", false, fmt.Errorf("symlink target %s leaves the repository (use --allow-external-links to create it)", target)
+	}
+	return destPath, !inside, nil
+}
+
+// maxLinkDepth bounds how many symlinks walkLinkTarget follows, like ELOOP
+const maxLinkDepth = 40
+
+// walkLinkTarget resolves target from dir one component at a time, the way
+// the OS will once the import is done. Symlinks are followed before ".."
+// is applied, both those on disk and those the archive creates, so a target
+// like "sub/d/../outside" where sub/d links to ".." cannot escape unnoticed.
+func walkLinkTarget(repo RepoExport, realRoot, dir, target string, depth int) (string, error) {
+	if depth > maxLinkDepth {
+		return "", fmt.Errorf("too many levels of symlinks")
+	}
+	cur := dir
+	if filepath.IsAbs(target) {
+		cur = string(filepath.Separator)
+	}
+	for _, name := range strings.Split(filepath.ToSlash(target), "/") {
+		switch name {
+		case "", ".":
+		case "..":
+			cur = filepath.Dir(cur)
+		default:
+			next, err := followLink(repo, realRoot, filepath.Join(cur, name), depth)
+			if err != nil {
+				return "", err
+			}
+			cur = next
+		}
+	}
+	return cur, nil
+}
+
+// followLink returns where p leads if it is a symlink in the archive or on
+// disk, and p itself otherwise
+func followLink(repo RepoExport, realRoot, p string, depth int) (string, error) {
+	var target string
+	if rel, err := filepath.Rel(realRoot, p); err == nil && isWithin(realRoot, p) {
+		target = repo.Attrs[filepath.ToSlash(rel)].Link
+	}
+	if target == "" {
+		info, err := os.Lstat(p)
+		switch {
+		case os.IsNotExist(err):
+			return p, nil
+		case err != nil:
+			return "", err
+		case info.Mode()&os.ModeSymlink == 0:
+			return p, nil
+		}
+		if target, err = os.Readlink(p); err != nil {
+			return "", err
+		}
+	}
+	return walkLinkTarget(repo, realRoot, filepath.Dir(p), target, depth+1)
+}
+
+// importSymlink recreates an archived symlink. An existing file or link at
+// the destination is handled by strategy; directories are never replaced.
+func importSymlink(tx *importTx, repo RepoExport, filePath, target, strategy string, summary *importSummary) {
+	destPath, _, err := resolveSymlink(repo, filePath, target)
+	if err != nil {
+		fmt.Printf("  ✗ %s: rejected: %v\n", filePath, err)
+		return
+	}
+
+	link := func(path string) error {
+		if err := tx.MkdirAll(filepath.Dir(path)); err != nil {
+			return err
+		}
+		return tx.Symlink(target, path)
+	}
+
+	info, err := os.Lstat(destPath)
+	if err != nil {
+		if err := link(destPath); err != nil {
+			fmt.Printf("  ✗ %s: %v\n", filePath, err)
+			summary.Failed = append(summary.Failed, destPath)
+			return
+		}
+		summary.Created = append(summary.Created, destPath)
+		fmt.Printf("  ✓ %s -> %s\n", destPath, target)
+		return
+	}
+
+	if current, err := os.Readlink(destPath); err == nil && current == target {
+		summary.Unchanged = append(summary.Unchanged, destPath)
+		return
+	}
+	if info.IsDir() {
+		fmt.Printf("  ✗ %s: destination is a directory\n", filePath)
+		summary.Failed = append(summary.Failed, destPath)
+		return
+	}
+
+	action := strategy
+	if action == conflictPrompt {
+		action = promptConflict(destPath, describeLinkOrFile(destPath, info), []byte("symlink -> "+target+"\n"))
+	}
+
+	written, err := writeConflictFile(tx, destPath, action, link, summary)
+	if err != nil {
+		fmt.Printf("  ✗ %s: %v\n", filePath, err)
+		summary.Failed = append(summary.Failed, destPath)
+		return
+	}
+	if written == "" {
+		fmt.Printf("  - %s (skipped)\n", destPath)
+	} else {
+		fmt.Printf("  ✓ %s -> %s\n", written, target)
+	}
+}
+
+// describeLinkOrFile returns the content of a file, or its target if it
+// is a symlink, for the conflict diff
+func describeLinkOrFile(path string, info os.FileInfo) []byte {
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, _ := os.Readlink(path)
+		return []byte("symlink -> " + target + "\n")
+	}
+	data, _ := os.ReadFile(path)
+	return data
+}
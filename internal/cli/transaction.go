@@ -0,0 +1,473 @@
+package cli
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/O6lvl4/igloc/internal/config"
+	"gopkg.in/yaml.v3"
+)
+
+// Journal states of a staged import
+const (
+	journalStaging    = "staging"    // files are being prepared; nothing was changed yet
+	journalCommitting = "committing" // staged files are being moved into place
+)
+
+// Journal operations
+const (
+	opWrite = "write" // move the staged file at Temp to Path
+	opMove  = "move"  // move From to Path, e.g. to keep a backup
+)
+
+// importJournal records a staged import so an interrupted run can be
+// finished or rolled back
+type importJournal struct {
+	ID      string      `yaml:"id"`
+	Archive string      `yaml:"archive"`
+	Started time.Time   `yaml:"started"`
+	State   string      `yaml:"state"`
+	Ops     []journalOp `yaml:"ops"`
+	Dirs    []string    `yaml:"dirs,omitempty"`    // created for the import, removed on rollback
+	History string      `yaml:"history,omitempty"` // ID of the undo record
+	path    string
+}
+
+type journalOp struct {
+	Kind string `yaml:"kind"`
+	Path string `yaml:"path"`
+	Temp string `yaml:"temp,omitempty"`
+	From string `yaml:"from,omitempty"`
+}
+
+// orig is where a write keeps the file it replaces until the import is
+// committed
+func (op journalOp) orig() string {
+	return op.Temp + ".orig"
+}
+
+// importTx applies the changes of an import. Every file is written to a
+// temporary file next to its destination and renamed into place, so a
+// destination is never left half-written. A staged transaction goes
+// further: nothing is renamed until commit, and the journal lets an
+// interrupted commit be finished with import --resume. With a record, the
+// previous state of every path is saved first so the import can be undone.
+type importTx struct {
+	journal *importJournal // nil unless staged
+	record  *importRecord  // nil when not recording
+}
+
+// newStagedTx starts a staged import of archive, refusing to start while
+// another import is unfinished
+func newStagedTx(archive string, record *importRecord) (*importTx, error) {
+	path, err := config.ImportJournalPath()
+	if err != nil {
+		return nil, err
+	}
+	if fileExists(path) {
+		return nil, fmt.Errorf("an interrupted import is pending; run igloc import --resume first")
+	}
+
+	id := make([]byte, 4)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	if abs, err := filepath.Abs(archive); err == nil && archive != stdioPath {
+		archive = abs
+	}
+	tx := &importTx{record: record, journal: &importJournal{
+		ID:      hex.EncodeToString(id),
+		Archive: archive,
+		Started: time.Now(),
+		State:   journalStaging,
+		path:    path,
+	}}
+	if record != nil {
+		tx.journal.History = record.ID
+	}
+	return tx, tx.journal.save()
+}
+
+func (tx *importTx) staged() bool {
+	return tx.journal != nil
+}
+
+// MkdirAll creates dir and its parents, remembering the ones it created so
+// a rollback can remove them
+func (tx *importTx) MkdirAll(dir string) error {
+	var created []string
+	for d := dir; !fileExists(d); d = filepath.Dir(d) {
+		created = append([]string{d}, created...)
+		if filepath.Dir(d) == d {
+			break
+		}
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	if len(created) == 0 {
+		return nil
+	}
+	if err := tx.record.addDirs(created); err != nil {
+		return err
+	}
+	if !tx.staged() {
+		return nil
+	}
+	if err := requireAbs(created...); err != nil {
+		return err
+	}
+	tx.journal.Dirs = append(tx.journal.Dirs, created...)
+	return tx.journal.save()
+}
+
+// WriteFile writes data to destPath with exactly mode, and restores a
+// non-zero modTime
+func (tx *importTx) WriteFile(destPath string, data []byte, mode os.FileMode, modTime time.Time) error {
+	return tx.place(destPath, func(temp string) error {
+		return writeTempFile(temp, data, mode, modTime)
+	})
+}
+
+// Symlink makes destPath a symlink to target, replacing what was there
+func (tx *importTx) Symlink(target, destPath string) error {
+	return tx.place(destPath, func(temp string) error {
+		return os.Symlink(target, temp)
+	})
+}
+
+// Rename moves an existing file out of the way, e.g. to keep a backup
+func (tx *importTx) Rename(from, to string) error {
+	if err := tx.record.capture(from); err != nil {
+		return err
+	}
+	if err := tx.record.capture(to); err != nil {
+		return err
+	}
+	if !tx.staged() {
+		return os.Rename(from, to)
+	}
+	if err := requireAbs(from, to); err != nil {
+		return err
+	}
+	tx.journal.Ops = append(tx.journal.Ops, journalOp{Kind: opMove, From: from, Path: to})
+	return tx.journal.save()
+}
+
+// place creates a new file with create at a temporary path next to
+// destPath, then renames it into place now or, when staged, at commit
+func (tx *importTx) place(destPath string, create func(temp string) error) error {
+	if err := tx.record.capture(destPath); err != nil {
+		return err
+	}
+
+	dir, base := filepath.Split(destPath)
+	if !tx.staged() {
+		temp, err := tempName(dir, base)
+		if err != nil {
+			return err
+		}
+		if err := create(temp); err != nil {
+			os.Remove(temp)
+			return err
+		}
+		if err := os.Rename(temp, destPath); err != nil {
+			os.Remove(temp)
+			return err
+		}
+		return nil
+	}
+
+	// Record the temporary file first so a rollback finds it
+	if err := requireAbs(destPath); err != nil {
+		return err
+	}
+	op := journalOp{
+		Kind: opWrite,
+		Path: destPath,
+		Temp: filepath.Join(dir, fmt.Sprintf(".%s.igloc-%s-%d", base, tx.journal.ID, len(tx.journal.Ops))),
+	}
+	tx.journal.Ops = append(tx.journal.Ops, op)
+	if err := tx.journal.save(); err != nil {
+		return err
+	}
+	return create(op.Temp)
+}
+
+// tempName returns an unused temporary path in dir
+func tempName(dir, base string) (string, error) {
+	suffix := make([]byte, 6)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, fmt.Sprintf(".%s.igloc-%s", base, hex.EncodeToString(suffix))), nil
+}
+
+// writeTempFile writes a new file and flushes it to disk. The file starts
+// private so the content is never readable by others while it is being
+// written, then gets the archived mode whatever the umask.
+func writeTempFile(path string, data []byte, mode os.FileMode, modTime time.Time) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if mode.Perm() != 0 {
+		if err := f.Chmod(mode.Perm()); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if !modTime.IsZero() {
+		return os.Chtimes(path, modTime, modTime)
+	}
+	return nil
+}
+
+// Commit applies a staged import. If a change fails, the ones already
+// applied are undone.
+func (tx *importTx) Commit() error {
+	if !tx.staged() {
+		return nil
+	}
+	return tx.journal.commit()
+}
+
+// Rollback discards a staged import and its undo record
+func (tx *importTx) Rollback() error {
+	if !tx.staged() {
+		return nil
+	}
+	if err := tx.journal.rollback(); err != nil {
+		return err
+	}
+	return tx.record.discard()
+}
+
+func (j *importJournal) commit() error {
+	j.State = journalCommitting
+	if err := j.save(); err != nil {
+		return err
+	}
+
+	for i, op := range j.Ops {
+		if err := op.apply(); err != nil {
+			err = fmt.Errorf("%s: %w", op.Path, err)
+			if rbErr := j.undo(i); rbErr != nil {
+				return fmt.Errorf("%w; rollback failed: %v (run igloc import --resume to finish the import)", err, rbErr)
+			}
+			return fmt.Errorf("%w (all changes were rolled back)", err)
+		}
+	}
+
+	// Everything is in place; the replaced files are no longer needed
+	for _, op := range j.Ops {
+		if op.Kind == opWrite {
+			os.Remove(op.orig())
+		}
+	}
+	return j.remove()
+}
+
+// apply performs one change. It can be repeated after a crash: each step
+// is skipped when its result is already there.
+func (op journalOp) apply() error {
+	switch op.Kind {
+	case opMove:
+		if _, err := os.Lstat(op.From); os.IsNotExist(err) {
+			return nil // moved before the interruption
+		}
+		return os.Rename(op.From, op.Path)
+
+	default:
+		if _, err := os.Lstat(op.Temp); os.IsNotExist(err) {
+			return nil // renamed into place before the interruption
+		}
+		if _, err := os.Lstat(op.Path); err == nil {
+			if err := os.Rename(op.Path, op.orig()); err != nil {
+				return err
+			}
+		}
+		return os.Rename(op.Temp, op.Path)
+	}
+}
+
+// undo reverts the changes up to and including index last, newest first
+func (j *importJournal) undo(last int) error {
+	var firstErr error
+	for i := last; i >= 0; i-- {
+		if err := j.Ops[i].revert(); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("%s: %w", j.Ops[i].Path, err)
+		}
+	}
+	if firstErr != nil {
+		return firstErr
+	}
+	return j.rollback()
+}
+
+// revert undoes apply, or whatever part of it was done
+func (op journalOp) revert() error {
+	switch op.Kind {
+	case opMove:
+		if _, err := os.Lstat(op.Path); err == nil && !fileExistsNoFollow(op.From) {
+			return os.Rename(op.Path, op.From)
+		}
+		return nil
+
+	default:
+		if _, err := os.Lstat(op.Temp); os.IsNotExist(err) {
+			// Applied: put the new file back into Temp so rollback deletes it
+			if err := os.Rename(op.Path, op.Temp); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		if fileExistsNoFollow(op.orig()) {
+			return os.Rename(op.orig(), op.Path)
+		}
+		return nil
+	}
+}
+
+// rollback removes the staged files and the directories created for them
+func (j *importJournal) rollback() error {
+	for _, op := range j.Ops {
+		if op.Kind == opWrite {
+			if err := os.Remove(op.Temp); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	for i := len(j.Dirs) - 1; i >= 0; i-- {
+		os.Remove(j.Dirs[i]) // only removed if still empty
+	}
+	return j.remove()
+}
+
+func (j *importJournal) save() error {
+	data, err := yaml.Marshal(j)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(j.path), 0755); err != nil {
+		return err
+	}
+	return writeFileAtomic(j.path, data, 0600)
+}
+
+// writeFileAtomic replaces path with data so that a crash never leaves it
+// truncated
+func writeFileAtomic(path string, data []byte, mode os.FileMode) error {
+	dir, base := filepath.Split(path)
+	temp, err := tempName(dir, base)
+	if err != nil {
+		return err
+	}
+	if err := writeTempFile(temp, data, mode, time.Time{}); err != nil {
+		os.Remove(temp)
+		return err
+	}
+	if err := os.Rename(temp, path); err != nil {
+		os.Remove(temp)
+		return err
+	}
+	return nil
+}
+
+func (j *importJournal) remove() error {
+	if err := os.Remove(j.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// loadImportJournal reads the journal of an unfinished import, or returns
+// nil if there is none
+func loadImportJournal() (*importJournal, error) {
+	path, err := config.ImportJournalPath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var j importJournal
+	if err := yaml.Unmarshal(data, &j); err != nil {
+		return nil, fmt.Errorf("invalid import journal %s: %w", path, err)
+	}
+	j.path = path
+	return &j, nil
+}
+
+// resumeImport finishes or rolls back an interrupted staged import. An
+// import interrupted while staging had not changed anything, so it is
+// rolled back; one interrupted while committing is completed.
+func resumeImport() error {
+	j, err := loadImportJournal()
+	if err != nil {
+		return err
+	}
+	if j == nil {
+		fmt.Println("No interrupted import to resume.")
+		return nil
+	}
+
+	// The undo record is kept or dropped along with the import
+	var record *importRecord
+	if j.History != "" {
+		if record, err = findImportRecord(j.History); err != nil {
+			fmt.Printf("Warning: %v\n", err)
+			record = nil
+		}
+	}
+
+	fmt.Printf("Interrupted import of %s (started %s)\n", j.Archive, j.Started.Format("2006-01-02 15:04:05"))
+	if j.State != journalCommitting {
+		if err := j.rollback(); err != nil {
+			return fmt.Errorf("failed to roll back: %w", err)
+		}
+		if err := record.discard(); err != nil {
+			return err
+		}
+		fmt.Println("It was interrupted before any file was changed; its staged files were removed.")
+		fmt.Println("Run the import again to apply it.")
+		return nil
+	}
+
+	if err := j.commit(); err != nil {
+		return err
+	}
+	if err := record.finish(); err != nil {
+		fmt.Printf("Warning: could not save the undo record: %v\n", err)
+	}
+	fmt.Printf("Import completed: %d changes applied.\n", len(j.Ops))
+	return nil
+}
+
+// fileExistsNoFollow reports whether path exists, without following a
+// final symlink
+func fileExistsNoFollow(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
@@ -0,0 +1,100 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// stageRelativeImport stages .env of a repository restored with a relative
+// --base from the directory work, the way import --atomic does
+func stageRelativeImport(t *testing.T, work string) (*importTx, string) {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	t.Chdir(work)
+
+	importBaseDir = "restore"
+	importDestinations = make(map[string]repoDestination)
+	t.Cleanup(func() {
+		importBaseDir = ""
+		importDestinations = make(map[string]repoDestination)
+	})
+
+	destPath, err := resolveDestPath(RepoExport{ID: "r", Files: []string{".env"}}, ".env")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := newStagedTx("backup.zip", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.MkdirAll(filepath.Dir(destPath)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.WriteFile(destPath, []byte("KEY=1\n"), 0600, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	return tx, destPath
+}
+
+func TestResumeFromAnotherDirectory(t *testing.T) {
+	work, elsewhere := t.TempDir(), t.TempDir()
+	tx, destPath := stageRelativeImport(t, work)
+
+	for _, op := range tx.journal.Ops {
+		if !filepath.IsAbs(op.Path) || !filepath.IsAbs(op.Temp) {
+			t.Fatalf("journal holds a relative path: %+v", op)
+		}
+	}
+
+	// Interrupted while committing, then resumed somewhere else
+	tx.journal.State = journalCommitting
+	if err := tx.journal.save(); err != nil {
+		t.Fatal(err)
+	}
+	t.Chdir(elsewhere)
+	captureStdout(t, func() {
+		if err := resumeImport(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	if data, err := os.ReadFile(destPath); err != nil || string(data) != "KEY=1\n" {
+		t.Errorf("%s = %q, %v", destPath, data, err)
+	}
+	if entries, _ := os.ReadDir(elsewhere); len(entries) > 0 {
+		t.Errorf("resume wrote into the current directory: %v", entries)
+	}
+}
+
+func TestRollbackFromAnotherDirectory(t *testing.T) {
+	work, elsewhere := t.TempDir(), t.TempDir()
+	stageRelativeImport(t, work)
+
+	// Interrupted while staging: resume removes the staged files
+	t.Chdir(elsewhere)
+	captureStdout(t, func() {
+		if err := resumeImport(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	if entries, _ := os.ReadDir(work); len(entries) > 0 {
+		t.Errorf("rollback left %v in %s", entries, work)
+	}
+}
+
+func TestStagedTxRefusesRelativePaths(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Chdir(t.TempDir())
+
+	tx, err := newStagedTx("backup.zip", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if err := tx.WriteFile(filepath.Join("r", ".env"), []byte("KEY=1\n"), 0600, time.Time{}); err == nil {
+		t.Error("WriteFile() accepted a relative path")
+	}
+}
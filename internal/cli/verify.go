@@ -0,0 +1,130 @@
+package cli
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+var verifyIdentity string
+
+// NewVerifyCmd creates the verify command
+func NewVerifyCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "verify [archive]",
+		Short: "Check an export archive for corrupted or missing files",
+		Long: `Check every file in an export archive against the SHA-256 digest and
+size recorded in its manifest. Encrypted archives are decrypted first,
+which also authenticates them.
+
+Zip, tar.gz and directory archives are detected automatically; "-"
+reads the archive from stdin.
+
+Archives created by older versions of igloc have no checksums; their
+files are reported as unverified. In any other archive, a file without a
+checksum fails verification.
+
+Examples:
+  igloc verify backup.zip
+  igloc verify --identity work.key backup.zip`,
+		Args: cobra.ExactArgs(1),
+		RunE: runVerify,
+	}
+
+	cmd.Flags().StringVar(&verifyIdentity, "identity", "", "Private key file for encrypted archives (default: ~/.config/igloc/identity.key)")
+
+	return cmd
+}
+
+func runVerify(cmd *cobra.Command, args []string) error {
+	reader, err := openArchive(args[0], verifyIdentity)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	manifest, err := readManifest(reader)
+	if err != nil {
+		return fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	verified, unverified, failed := 0, 0, 0
+	for _, repo := range manifest.Repos {
+		fmt.Printf("📂 %s\n", repo.ID)
+		for _, filePath := range repo.Files {
+			name := "files/" + repo.ID + "/" + filePath
+
+			// Symlinks are only recorded in the manifest
+			if link := repo.Attrs[filePath].Link; link != "" {
+				fmt.Printf("   ✓ %s -> %s (symlink)\n", filePath, link)
+				continue
+			}
+
+			entry, ok := reader.Lookup(name)
+			if !ok {
+				fmt.Printf("   ✗ %s: missing from archive\n", filePath)
+				failed++
+				continue
+			}
+
+			// Reading a zip entry also checks its CRC-32
+			data, err := entry.Read()
+			if err != nil {
+				fmt.Printf("   ✗ %s: %v\n", filePath, err)
+				failed++
+				continue
+			}
+
+			ok, err = verifyChecksum(repo, filePath, data)
+			switch {
+			case err != nil:
+				fmt.Printf("   ✗ %s: %v\n", filePath, err)
+				failed++
+			case !ok:
+				fmt.Printf("   ? %s (no checksum recorded)\n", filePath)
+				unverified++
+			default:
+				fmt.Printf("   ✓ %s\n", filePath)
+				verified++
+			}
+		}
+	}
+
+	for _, name := range unlistedEntries(reader, manifest) {
+		fmt.Printf("Warning: %s is not listed in the manifest and will not be imported\n", name)
+	}
+
+	fmt.Printf("\n%d verified, %d unverified, %d failed\n", verified, unverified, failed)
+
+	if failed > 0 {
+		// Verification failures are not usage errors; main reports the error once
+		cmd.SilenceUsage = true
+		cmd.SilenceErrors = true
+		return fmt.Errorf("archive is corrupted: %d files failed verification", failed)
+	}
+	return nil
+}
+
+// verifyChecksum compares data with the manifest checksum of a file. It
+// returns false without an error only for archives that record no
+// checksums; export records one for every file, so a missing one in any
+// other archive is an error.
+func verifyChecksum(repo RepoExport, filePath string, data []byte) (bool, error) {
+	sum, ok := repo.Checksums[filePath]
+	switch {
+	case !ok && len(repo.Checksums) == 0:
+		return false, nil
+	case !ok:
+		return false, fmt.Errorf("no checksum recorded")
+	}
+	if int64(len(data)) != sum.Size {
+		return false, fmt.Errorf("size mismatch: expected %d bytes, got %d", sum.Size, len(data))
+	}
+	digest := sha256.Sum256(data)
+	if hex.EncodeToString(digest[:]) != sum.SHA256 {
+		return false, fmt.Errorf("checksum mismatch")
+	}
+	return true, nil
+}
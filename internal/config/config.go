@@ -106,3 +106,83 @@ func (c *PatternsConfig) GetAllDepsDirs() []string {
 
 	return result
 }
+
+// RulesConfig holds user-defined file classification rules
+type RulesConfig struct {
+	Version    int            `yaml:"version"`
+	Replace    bool           `yaml:"replace,omitempty"` // drop the built-in rules instead of extending them
+	Categories []CategoryRule `yaml:"categories"`
+}
+
+// CategoryRule assigns a category to files matching any of its matchers.
+// Globs without a slash match the file name, others the relative path.
+type CategoryRule struct {
+	Name     string   `yaml:"name"`
+	Icon     string   `yaml:"icon,omitempty"`
+	Priority int      `yaml:"priority"`
+	Secret   bool     `yaml:"secret"`
+	Globs    []string `yaml:"globs,omitempty"`
+	Regex    []string `yaml:"regex,omitempty"`
+}
+
+// RulesFilePath returns the path to rules.yaml
+func RulesFilePath() (string, error) {
+	dir, err := DefaultConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "rules.yaml"), nil
+}
+
+// LoadRules loads classification rules from the config file
+func LoadRules() (*RulesConfig, error) {
+	path, err := RulesFilePath()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil // No rules file, use built-in rules
+		}
+		return nil, err
+	}
+
+	var config RulesConfig
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+
+	return &config, nil
+}
+
+// IdentityFilePath returns the path to the private key used to decrypt
+// archives
+func IdentityFilePath() (string, error) {
+	dir, err := DefaultConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "identity.key"), nil
+}
+
+// ImportJournalPath returns the path to the journal of an import that is
+// being applied
+func ImportJournalPath() (string, error) {
+	dir, err := DefaultConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "import-journal.yaml"), nil
+}
+
+// ImportHistoryDir returns the directory that keeps the undo records and
+// replaced files of past imports
+func ImportHistoryDir() (string, error) {
+	dir, err := DefaultConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "imports"), nil
+}
@@ -0,0 +1,343 @@
+// Package encrypt seals export archives with a passphrase and/or X25519
+// recipient keys.
+//
+// An encrypted archive is laid out as:
+//
+//	magic "IGLOCENC" | version (1 byte) | header length (uint32) | header (JSON) | nonce | ciphertext
+//
+// The payload is encrypted with AES-256-GCM under a random data key, using
+// the magic, version and header as additional data so that any change to
+// the file is detected. The header stores the data key wrapped once for
+// the passphrase (Argon2id) and once per recipient (X25519 + HKDF).
+package encrypt
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	magic   = "IGLOCENC"
+	version = 1
+
+	keySize = 32 // AES-256
+)
+
+// Argon2id parameters for new archives, per RFC 9106's second recommended option
+const (
+	argonTime    = 3
+	argonMemory  = 64 * 1024 // KiB
+	argonThreads = 4
+)
+
+// Limits on the Argon2id parameters read from an archive header. The header
+// is only authenticated once the data key is unwrapped, so a tampered header
+// must not be able to crash the KDF or make it allocate without bound.
+const (
+	maxArgonTime    = 10
+	maxArgonMemory  = 1024 * 1024 // KiB (1 GiB)
+	maxArgonThreads = 16
+)
+
+var (
+	// ErrNoKey is returned when neither a passphrase nor a matching identity
+	// was supplied for an archive
+	ErrNoKey = errors.New("no passphrase or matching identity for this archive")
+
+	// ErrDecrypt is returned when authentication fails: a wrong passphrase
+	// or key, or an archive that was corrupted or tampered with
+	ErrDecrypt = errors.New("decryption failed: wrong passphrase or key, or the archive was modified")
+)
+
+// header describes how the data key is protected
+type header struct {
+	Cipher     string         `json:"cipher"`
+	Passphrase *passphraseKey `json:"passphrase,omitempty"`
+	Recipients []recipientKey `json:"recipients,omitempty"`
+}
+
+type passphraseKey struct {
+	KDF        string `json:"kdf"`
+	Salt       []byte `json:"salt"`
+	Time       uint32 `json:"time"`
+	Memory     uint32 `json:"memory"`
+	Threads    uint8  `json:"threads"`
+	WrappedKey []byte `json:"wrapped_key"`
+}
+
+type recipientKey struct {
+	Type       string `json:"type"`
+	Ephemeral  []byte `json:"ephemeral"` // sender's ephemeral X25519 public key
+	WrappedKey []byte `json:"wrapped_key"`
+}
+
+// Options selects how an archive is sealed. At least one of Passphrase or
+// Recipients must be set.
+type Options struct {
+	Passphrase []byte
+	Recipients []*ecdh.PublicKey
+}
+
+// IsEncrypted reports whether data starts with the encrypted archive magic
+func IsEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(magic))
+}
+
+// Seal encrypts plaintext for the given passphrase and recipients
+func Seal(plaintext []byte, opts Options) ([]byte, error) {
+	if len(opts.Passphrase) == 0 && len(opts.Recipients) == 0 {
+		return nil, errors.New("a passphrase or at least one recipient is required")
+	}
+
+	dataKey := make([]byte, keySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+
+	hdr := header{Cipher: "aes-256-gcm"}
+
+	if len(opts.Passphrase) > 0 {
+		salt := make([]byte, 16)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, err
+		}
+		kek := argon2.IDKey(opts.Passphrase, salt, argonTime, argonMemory, argonThreads, keySize)
+		wrapped, err := wrapKey(kek, dataKey, []byte("passphrase"))
+		if err != nil {
+			return nil, err
+		}
+		hdr.Passphrase = &passphraseKey{
+			KDF:        "argon2id",
+			Salt:       salt,
+			Time:       argonTime,
+			Memory:     argonMemory,
+			Threads:    argonThreads,
+			WrappedKey: wrapped,
+		}
+	}
+
+	for _, recipient := range opts.Recipients {
+		ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		kek, err := recipientKEK(ephemeral, recipient, ephemeral.PublicKey(), recipient)
+		if err != nil {
+			return nil, err
+		}
+		wrapped, err := wrapKey(kek, dataKey, []byte("x25519"))
+		if err != nil {
+			return nil, err
+		}
+		hdr.Recipients = append(hdr.Recipients, recipientKey{
+			Type:       "x25519",
+			Ephemeral:  ephemeral.PublicKey().Bytes(),
+			WrappedKey: wrapped,
+		})
+	}
+
+	hdrData, err := json.Marshal(hdr)
+	if err != nil {
+		return nil, err
+	}
+
+	var prefix bytes.Buffer
+	prefix.WriteString(magic)
+	prefix.WriteByte(version)
+	binary.Write(&prefix, binary.BigEndian, uint32(len(hdrData)))
+	prefix.Write(hdrData)
+
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	out := append(prefix.Bytes(), nonce...)
+	return aead.Seal(out, nonce, plaintext, prefix.Bytes()), nil
+}
+
+// Open decrypts an archive with a passphrase or one of the identities
+func Open(data []byte, passphrase []byte, identities []*ecdh.PrivateKey) ([]byte, error) {
+	hdr, prefixLen, err := parseHeader(data)
+	if err != nil {
+		return nil, err
+	}
+
+	dataKey, err := unwrapDataKey(hdr, passphrase, identities)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < prefixLen+aead.NonceSize() {
+		return nil, ErrDecrypt
+	}
+	nonce := data[prefixLen : prefixLen+aead.NonceSize()]
+	plaintext, err := aead.Open(nil, nonce, data[prefixLen+aead.NonceSize():], data[:prefixLen])
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plaintext, nil
+}
+
+// NeedsPassphrase reports whether an archive can only be opened with a
+// passphrase given the identities at hand
+func NeedsPassphrase(data []byte, identities []*ecdh.PrivateKey) (bool, error) {
+	hdr, _, err := parseHeader(data)
+	if err != nil {
+		return false, err
+	}
+	if hdr.Passphrase == nil {
+		return false, nil
+	}
+	if _, err := unwrapDataKey(hdr, nil, identities); err == nil {
+		return false, nil
+	}
+	return true, nil
+}
+
+func parseHeader(data []byte) (*header, int, error) {
+	if !IsEncrypted(data) {
+		return nil, 0, errors.New("not an encrypted archive")
+	}
+	pos := len(magic)
+	if len(data) < pos+5 {
+		return nil, 0, ErrDecrypt
+	}
+	if data[pos] != version {
+		return nil, 0, fmt.Errorf("unsupported encrypted archive version %d", data[pos])
+	}
+	hdrLen := int(binary.BigEndian.Uint32(data[pos+1 : pos+5]))
+	pos += 5
+	if hdrLen > len(data)-pos {
+		return nil, 0, ErrDecrypt
+	}
+
+	var hdr header
+	if err := json.Unmarshal(data[pos:pos+hdrLen], &hdr); err != nil {
+		return nil, 0, fmt.Errorf("invalid encrypted archive header: %w", err)
+	}
+	if hdr.Cipher != "aes-256-gcm" {
+		return nil, 0, fmt.Errorf("unsupported cipher: %s", hdr.Cipher)
+	}
+	if p := hdr.Passphrase; p != nil {
+		if err := p.validate(); err != nil {
+			return nil, 0, fmt.Errorf("invalid encrypted archive header: %w", err)
+		}
+	}
+	return &hdr, pos + hdrLen, nil
+}
+
+// validate checks the key derivation parameters are within the range igloc
+// uses, rejecting headers that would crash or exhaust memory in Argon2
+func (p *passphraseKey) validate() error {
+	if p.KDF != "argon2id" {
+		return fmt.Errorf("unsupported key derivation: %s", p.KDF)
+	}
+	if p.Time < 1 || p.Time > maxArgonTime {
+		return fmt.Errorf("argon2 time %d out of range (1-%d)", p.Time, maxArgonTime)
+	}
+	if p.Threads < 1 || p.Threads > maxArgonThreads {
+		return fmt.Errorf("argon2 threads %d out of range (1-%d)", p.Threads, maxArgonThreads)
+	}
+	// Argon2 needs at least 8 KiB per thread
+	if p.Memory < 8*uint32(p.Threads) || p.Memory > maxArgonMemory {
+		return fmt.Errorf("argon2 memory %d KiB out of range (%d-%d)", p.Memory, 8*uint32(p.Threads), maxArgonMemory)
+	}
+	return nil
+}
+
+// unwrapDataKey tries each identity against each recipient entry, then
+// the passphrase
+func unwrapDataKey(hdr *header, passphrase []byte, identities []*ecdh.PrivateKey) ([]byte, error) {
+	for _, identity := range identities {
+		for _, r := range hdr.Recipients {
+			if r.Type != "x25519" {
+				continue
+			}
+			ephemeral, err := ecdh.X25519().NewPublicKey(r.Ephemeral)
+			if err != nil {
+				continue
+			}
+			kek, err := recipientKEK(identity, ephemeral, ephemeral, identity.PublicKey())
+			if err != nil {
+				continue
+			}
+			if key, err := unwrapKey(kek, r.WrappedKey, []byte("x25519")); err == nil {
+				return key, nil
+			}
+		}
+	}
+
+	if hdr.Passphrase != nil && len(passphrase) > 0 {
+		p := hdr.Passphrase
+		kek := argon2.IDKey(passphrase, p.Salt, p.Time, p.Memory, p.Threads, keySize)
+		key, err := unwrapKey(kek, p.WrappedKey, []byte("passphrase"))
+		if err != nil {
+			return nil, ErrDecrypt
+		}
+		return key, nil
+	}
+
+	return nil, ErrNoKey
+}
+
+// recipientKEK derives a key-encryption key from an X25519 exchange,
+// binding both the ephemeral and the recipient public keys
+func recipientKEK(private *ecdh.PrivateKey, peer, ephemeral, recipient *ecdh.PublicKey) ([]byte, error) {
+	shared, err := private.ECDH(peer)
+	if err != nil {
+		return nil, err
+	}
+	salt := append(append([]byte{}, ephemeral.Bytes()...), recipient.Bytes()...)
+	return hkdf.Key(sha256.New, shared, salt, "igloc x25519 key wrap", keySize)
+}
+
+func wrapKey(kek, key, aad []byte) ([]byte, error) {
+	aead, err := newGCM(kek)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, key, aad), nil
+}
+
+func unwrapKey(kek, wrapped, aad []byte) ([]byte, error) {
+	aead, err := newGCM(kek)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < aead.NonceSize() {
+		return nil, ErrDecrypt
+	}
+	return aead.Open(nil, wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():], aad)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
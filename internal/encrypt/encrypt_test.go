@@ -0,0 +1,93 @@
+package encrypt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+// withHeader re-encodes a sealed archive with its header changed by edit,
+// keeping the original nonce and ciphertext
+func withHeader(t *testing.T, sealed []byte, edit func(*header)) []byte {
+	t.Helper()
+	hdr, prefixLen, err := parseHeader(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edit(hdr)
+	hdrData, err := json.Marshal(hdr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	out.WriteString(magic)
+	out.WriteByte(version)
+	binary.Write(&out, binary.BigEndian, uint32(len(hdrData)))
+	out.Write(hdrData)
+	out.Write(sealed[prefixLen:])
+	return out.Bytes()
+}
+
+func TestOpenRoundTrip(t *testing.T) {
+	sealed, err := Seal([]byte("SECRET=1\n"), Options{Passphrase: []byte("pw")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext, err := Open(sealed, []byte("pw"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(plaintext) != "SECRET=1\n" {
+		t.Errorf("Open() = %q", plaintext)
+	}
+	if _, err := Open(sealed, []byte("wrong"), nil); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("Open() with a wrong passphrase: err = %v, want ErrDecrypt", err)
+	}
+}
+
+func TestOpenTamperedKDFParams(t *testing.T) {
+	sealed, err := Seal([]byte("SECRET=1\n"), Options{Passphrase: []byte("pw")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		edit func(p *passphraseKey)
+	}{
+		{"zero time", func(p *passphraseKey) { p.Time = 0 }},
+		{"huge time", func(p *passphraseKey) { p.Time = 1 << 30 }},
+		{"zero threads", func(p *passphraseKey) { p.Threads = 0 }},
+		{"too many threads", func(p *passphraseKey) { p.Threads = 255 }},
+		{"zero memory", func(p *passphraseKey) { p.Memory = 0 }},
+		{"huge memory", func(p *passphraseKey) { p.Memory = 1 << 31 }},
+		{"other kdf", func(p *passphraseKey) { p.KDF = "scrypt" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tampered := withHeader(t, sealed, func(h *header) { tt.edit(h.Passphrase) })
+			if _, err := Open(tampered, []byte("pw"), nil); err == nil {
+				t.Fatal("Open() succeeded on a tampered header")
+			}
+			if _, err := NeedsPassphrase(tampered, nil); err == nil {
+				t.Fatal("NeedsPassphrase() succeeded on a tampered header")
+			}
+		})
+	}
+}
+
+func TestOpenTamperedHeaderWithinLimits(t *testing.T) {
+	sealed, err := Seal([]byte("SECRET=1\n"), Options{Passphrase: []byte("pw")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Valid parameters still fail authentication, since the header is
+	// additional data of the payload
+	tampered := withHeader(t, sealed, func(h *header) { h.Passphrase.Time = 1 })
+	if _, err := Open(tampered, []byte("pw"), nil); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("Open() err = %v, want ErrDecrypt", err)
+	}
+}
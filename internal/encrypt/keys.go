@@ -0,0 +1,70 @@
+package encrypt
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// Key string prefixes; the rest is unpadded base64url of the raw key
+const (
+	publicKeyPrefix  = "iglocpub1"
+	privateKeyPrefix = "IGLOC-SECRET-KEY-1"
+)
+
+// GenerateIdentity creates a new X25519 key pair
+func GenerateIdentity() (*ecdh.PrivateKey, error) {
+	return ecdh.X25519().GenerateKey(rand.Reader)
+}
+
+// EncodePublicKey formats a recipient key for sharing
+func EncodePublicKey(key *ecdh.PublicKey) string {
+	return publicKeyPrefix + base64.RawURLEncoding.EncodeToString(key.Bytes())
+}
+
+// ParsePublicKey parses a recipient key produced by EncodePublicKey
+func ParsePublicKey(s string) (*ecdh.PublicKey, error) {
+	s = strings.TrimSpace(s)
+	raw, ok := strings.CutPrefix(s, publicKeyPrefix)
+	if !ok {
+		return nil, fmt.Errorf("invalid recipient %q: must start with %s", s, publicKeyPrefix)
+	}
+	data, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid recipient %q: %w", s, err)
+	}
+	return ecdh.X25519().NewPublicKey(data)
+}
+
+// EncodeIdentity formats a private key for the identity file
+func EncodeIdentity(key *ecdh.PrivateKey) string {
+	return privateKeyPrefix + base64.RawURLEncoding.EncodeToString(key.Bytes())
+}
+
+// ParseIdentities reads private keys from an identity file. Blank lines and
+// lines starting with # are skipped.
+func ParseIdentities(data []byte) ([]*ecdh.PrivateKey, error) {
+	var keys []*ecdh.PrivateKey
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		raw, ok := strings.CutPrefix(line, privateKeyPrefix)
+		if !ok {
+			return nil, fmt.Errorf("line %d: not an igloc secret key", i+1)
+		}
+		b, err := base64.RawURLEncoding.DecodeString(raw)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		key, err := ecdh.X25519().NewPrivateKey(b)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
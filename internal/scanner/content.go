@@ -0,0 +1,137 @@
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"regexp"
+)
+
+// DefaultMaxContentSize is the largest file inspected for secret content
+const DefaultMaxContentSize = 1 << 20 // 1 MiB
+
+// binarySniffLen is how many leading bytes are checked for binary content
+const binarySniffLen = 8000
+
+// ContentRule describes a known credential format
+type ContentRule struct {
+	ID          string
+	Description string
+	Pattern     *regexp.Regexp
+}
+
+// Finding records a content rule that matched inside a file
+type Finding struct {
+	RuleID  string  `json:"rule_id"`
+	Line    int     `json:"line"`
+	Entropy float64 `json:"entropy,omitempty"` // bits per character, set for entropy findings only
+}
+
+// contentRules are the built-in credential formats
+var contentRules = []ContentRule{
+	{
+		ID:          "aws-access-key-id",
+		Description: "AWS access key ID",
+		Pattern:     regexp.MustCompile(`\b(AKIA|ASIA|ABIA|ACCA)[0-9A-Z]{16}\b`),
+	},
+	{
+		ID:          "aws-secret-access-key",
+		Description: "AWS secret access key",
+		Pattern:     regexp.MustCompile(`(?i)aws_?secret_?(access_?)?key["']?\s*[:=]\s*["']?[A-Za-z0-9/+=]{40}\b`),
+	},
+	{
+		ID:          "github-token",
+		Description: "GitHub token",
+		Pattern:     regexp.MustCompile(`\b(gh[pousr]_[A-Za-z0-9]{36,255}|github_pat_[A-Za-z0-9_]{82})\b`),
+	},
+	{
+		ID:          "slack-token",
+		Description: "Slack token",
+		Pattern:     regexp.MustCompile(`\bxox[abeprs]-[A-Za-z0-9-]{10,}`),
+	},
+	{
+		ID:          "slack-webhook",
+		Description: "Slack incoming webhook URL",
+		Pattern:     regexp.MustCompile(`https://hooks\.slack\.com/services/T[A-Za-z0-9_]+/B[A-Za-z0-9_]+/[A-Za-z0-9_]+`),
+	},
+	{
+		ID:          "private-key",
+		Description: "Private key block",
+		Pattern:     regexp.MustCompile(`-----BEGIN ((RSA|DSA|EC|OPENSSH|PGP|ENCRYPTED) )?PRIVATE KEY( BLOCK)?-----`),
+	},
+	{
+		ID:          "jwt",
+		Description: "JSON Web Token",
+		Pattern:     regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{10,}\.eyJ[A-Za-z0-9_-]{10,}\.[A-Za-z0-9_-]{10,}`),
+	},
+	{
+		ID:          "connection-string",
+		Description: "Connection string with password",
+		Pattern:     regexp.MustCompile(`(?i)\b(postgres(ql)?|mysql|mariadb|mongodb(\+srv)?|redis|rediss|amqps?|mssql|sqlserver|ldaps?|smtps?|ftps?)://[^\s:/@'"]+:[^\s@/'"]+@[^\s'"]+`),
+	},
+}
+
+// ContentRules returns the built-in content rules
+func ContentRules() []ContentRule {
+	return contentRules
+}
+
+// inspectContent reads a file and returns the content rules that match it.
+// Files larger than MaxContentSize and binary files are skipped.
+func (s *Scanner) inspectContent(path string) []Finding {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil || !info.Mode().IsRegular() || info.Size() == 0 || info.Size() > s.MaxContentSize {
+		return nil
+	}
+
+	data, err := io.ReadAll(io.LimitReader(file, s.MaxContentSize))
+	if err != nil || isBinary(data) {
+		return nil
+	}
+
+	return s.matchContent(data)
+}
+
+// matchContent runs every content rule against each line of data, falling
+// back to the entropy detector for lines no rule recognized
+func (s *Scanner) matchContent(data []byte) []Finding {
+	var findings []Finding
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), int(s.MaxContentSize)+1)
+
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := scanner.Bytes()
+
+		matched := false
+		for _, rule := range contentRules {
+			if rule.Pattern.Match(line) {
+				findings = append(findings, Finding{RuleID: rule.ID, Line: lineNum})
+				matched = true
+			}
+		}
+
+		if !matched && s.DetectEntropy {
+			findings = append(findings, s.Entropy.matchEntropy(string(line), lineNum)...)
+		}
+	}
+
+	return findings
+}
+
+// isBinary reports whether data looks like a binary file
+func isBinary(data []byte) bool {
+	if len(data) > binarySniffLen {
+		data = data[:binarySniffLen]
+	}
+	return bytes.IndexByte(data, 0) >= 0
+}
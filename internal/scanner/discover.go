@@ -0,0 +1,178 @@
+package scanner
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+)
+
+// skipDiscoveryDirs are never searched for nested repositories
+var skipDiscoveryDirs = map[string]bool{
+	"node_modules": true,
+	"vendor":       true,
+	".cache":       true,
+	"__pycache__":  true,
+}
+
+// DefaultJobs returns the default number of concurrent workers
+func DefaultJobs() int {
+	return runtime.NumCPU()
+}
+
+// repoFinder walks directory trees with a fixed number of workers looking
+// for .git directories. Directories still to be read wait in a queue, so
+// wide trees don't start a goroutine per directory.
+type repoFinder struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	queue   []string
+	pending int // directories queued or being read
+	repos   []string
+}
+
+// FindRepos returns the git repositories below rootPath, sorted by path.
+// At most jobs directories are read at the same time.
+func FindRepos(rootPath string, jobs int) ([]string, error) {
+	if jobs < 1 {
+		jobs = 1
+	}
+
+	// Fail early if the root itself can't be read
+	if _, err := os.ReadDir(rootPath); err != nil {
+		return nil, err
+	}
+
+	f := &repoFinder{queue: []string{rootPath}, pending: 1}
+	f.cond = sync.NewCond(&f.mu)
+
+	var wg sync.WaitGroup
+	for w := 0; w < jobs; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f.work()
+		}()
+	}
+	wg.Wait()
+
+	sort.Strings(f.repos)
+	return f.repos, nil
+}
+
+// work reads queued directories until every directory has been read
+func (f *repoFinder) work() {
+	for {
+		f.mu.Lock()
+		for len(f.queue) == 0 && f.pending > 0 {
+			f.cond.Wait()
+		}
+		if len(f.queue) == 0 {
+			f.mu.Unlock()
+			return
+		}
+		// Last in, first out keeps the queue short on deep trees
+		dir := f.queue[len(f.queue)-1]
+		f.queue = f.queue[:len(f.queue)-1]
+		f.mu.Unlock()
+
+		subdirs, isRepo := readDiscoveryDir(dir)
+
+		f.mu.Lock()
+		if isRepo {
+			f.repos = append(f.repos, dir)
+		}
+		f.queue = append(f.queue, subdirs...)
+		f.pending += len(subdirs) - 1
+		f.cond.Broadcast()
+		f.mu.Unlock()
+	}
+}
+
+// readDiscoveryDir returns the subdirectories of dir to search and whether
+// dir is a repository
+func readDiscoveryDir(dir string) ([]string, bool) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, false // skip unreadable directories
+	}
+
+	var subdirs []string
+	isRepo := false
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		// The repository itself is scanned; keep looking for nested ones
+		if entry.Name() == ".git" {
+			isRepo = true
+			continue
+		}
+
+		if skipDiscoveryDirs[entry.Name()] {
+			continue
+		}
+
+		subdirs = append(subdirs, filepath.Join(dir, entry.Name()))
+	}
+	return subdirs, isRepo
+}
+
+// ScanRecursive finds the git repositories below rootPath and scans them
+// with up to jobs workers. fn is called for each repository in path order,
+// as soon as it and every repository before it have been scanned. A
+// repository that fails to scan is passed as a nil result and an error
+// naming it, so callers can warn about it and go on.
+func (s *Scanner) ScanRecursive(rootPath string, jobs int, fn func(*ScanResult, error)) error {
+	if jobs < 1 {
+		jobs = 1
+	}
+
+	if err := s.compileFilter(); err != nil {
+		return err
+	}
+
+	repos, err := FindRepos(rootPath, jobs)
+	if err != nil {
+		return err
+	}
+	repos = s.selectRepos(rootPath, repos)
+
+	results := make([]*ScanResult, len(repos))
+	errs := make([]error, len(repos))
+	done := make([]chan struct{}, len(repos))
+	for i := range done {
+		done[i] = make(chan struct{})
+	}
+
+	work := make(chan int)
+	go func() {
+		for i := range repos {
+			work <- i
+		}
+		close(work)
+	}()
+
+	for w := 0; w < jobs; w++ {
+		go func() {
+			for i := range work {
+				if result, err := s.Scan(repos[i]); err != nil {
+					errs[i] = fmt.Errorf("%s: %w", repos[i], err)
+				} else {
+					results[i] = result
+				}
+				close(done[i])
+			}
+		}()
+	}
+
+	for i := range repos {
+		<-done[i]
+		fn(results[i], errs[i])
+	}
+
+	return nil
+}
@@ -0,0 +1,122 @@
+package scanner
+
+import (
+	"math"
+	"regexp"
+	"strings"
+)
+
+// Entropy finding rule IDs
+const (
+	RuleHighEntropyBase64 = "high-entropy-base64"
+	RuleHighEntropyHex    = "high-entropy-hex"
+)
+
+// EntropyOptions configures the high-entropy string detector
+type EntropyOptions struct {
+	Base64Threshold float64 // minimum bits per char for base64-like values
+	HexThreshold    float64 // minimum bits per char for hex values
+	MinLength       int     // ignore shorter values
+}
+
+// DefaultEntropyOptions returns thresholds that catch random API secrets
+// while leaving ordinary words and identifiers alone. A value of n
+// characters has at most log2(n) bits per char, so MinLength is the
+// shortest value that can reach the base64 threshold.
+func DefaultEntropyOptions() EntropyOptions {
+	return EntropyOptions{
+		Base64Threshold: 4.5,
+		HexThreshold:    3.0,
+		MinLength:       24,
+	}
+}
+
+// assignmentPattern matches KEY=value, key: value and "key": "value" lines
+var assignmentPattern = regexp.MustCompile(`^\s*(?:export\s+)?["']?[A-Za-z0-9_.\-]+["']?\s*[:=]\s*(.+?)\s*,?\s*$`)
+
+// tokenSeparators splits values into runs of base64 characters
+var tokenSeparators = regexp.MustCompile(`[^A-Za-z0-9+/=_\-]+`)
+
+// matchEntropy returns entropy findings for the value assigned on a line
+func (o EntropyOptions) matchEntropy(line string, lineNum int) []Finding {
+	trimmed := strings.TrimSpace(line)
+	if trimmed == "" || strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, "//") {
+		return nil
+	}
+
+	m := assignmentPattern.FindStringSubmatch(line)
+	if m == nil {
+		return nil
+	}
+	value := strings.Trim(m[1], `"'`)
+
+	var findings []Finding
+	for _, token := range tokenSeparators.Split(value, -1) {
+		if len(token) < o.MinLength {
+			continue
+		}
+
+		entropy := shannonEntropy(token)
+		if isDecimal(token) {
+			// IDs, timestamps and account numbers; at most log2(10) bits per char
+			continue
+		}
+		if isHex(token) {
+			if entropy >= o.HexThreshold {
+				findings = append(findings, Finding{RuleID: RuleHighEntropyHex, Line: lineNum, Entropy: entropy})
+			}
+		} else if entropy >= o.Base64Threshold {
+			findings = append(findings, Finding{RuleID: RuleHighEntropyBase64, Line: lineNum, Entropy: entropy})
+		}
+	}
+
+	return findings
+}
+
+// threshold returns the entropy threshold that applies to a finding's rule
+func (o EntropyOptions) threshold(ruleID string) float64 {
+	if ruleID == RuleHighEntropyHex {
+		return o.HexThreshold
+	}
+	return o.Base64Threshold
+}
+
+// shannonEntropy returns the Shannon entropy of s in bits per character
+func shannonEntropy(s string) float64 {
+	if s == "" {
+		return 0
+	}
+
+	counts := make(map[rune]int)
+	for _, r := range s {
+		counts[r]++
+	}
+
+	n := float64(len(s))
+	var entropy float64
+	for _, c := range counts {
+		p := float64(c) / n
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
+
+// isHex reports whether s consists only of hexadecimal digits
+func isHex(s string) bool {
+	for _, r := range s {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", r) {
+			return false
+		}
+	}
+	return true
+}
+
+// isDecimal reports whether s consists only of decimal digits
+func isDecimal(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,105 @@
+package scanner
+
+import "path/filepath"
+
+// fileFilter holds the compiled Include, Exclude and Repos globs of a
+// scanner
+type fileFilter struct {
+	include compiledRule
+	exclude compiledRule
+	repos   compiledRule
+}
+
+// compileFilter compiles the filter globs once; Scan and ScanRecursive
+// call it so that a bad glob is reported before anything is scanned
+func (s *Scanner) compileFilter() error {
+	s.filterOnce.Do(func() {
+		f := &fileFilter{}
+		if err := f.include.addGlobs(s.Include); err != nil {
+			s.filterErr = err
+			return
+		}
+		if err := f.exclude.addGlobs(s.Exclude); err != nil {
+			s.filterErr = err
+			return
+		}
+		if err := f.repos.addGlobs(s.Repos); err != nil {
+			s.filterErr = err
+			return
+		}
+		s.filter = f
+	})
+	return s.filterErr
+}
+
+// selects reports whether a file passes the Categories, Include, Exclude
+// and MaxSize filters. It is checked before the content is inspected.
+func (s *Scanner) selects(path, category string, size int64) bool {
+	if len(s.Categories) > 0 {
+		found := false
+		for _, c := range s.Categories {
+			if c == category {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if s.MaxSize > 0 && size > s.MaxSize {
+		return false
+	}
+
+	if s.filter == nil {
+		return true
+	}
+	if len(s.Include) > 0 && !s.filter.include.matchesPath(path) {
+		return false
+	}
+	return !s.filter.exclude.matchesPath(path)
+}
+
+// selectRepos keeps the repositories whose directory name or path relative
+// to rootPath matches one of the Repos globs
+func (s *Scanner) selectRepos(rootPath string, repos []string) []string {
+	if len(s.Repos) == 0 {
+		return repos
+	}
+
+	var selected []string
+	for _, repo := range repos {
+		rel, err := filepath.Rel(rootPath, repo)
+		if err != nil {
+			continue
+		}
+		if rel == "." {
+			rel = filepath.Base(repo)
+		}
+		if s.filter.repos.matchesPath(filepath.ToSlash(rel)) {
+			selected = append(selected, repo)
+		}
+	}
+	return selected
+}
+
+// GlobSet matches paths the way the Include and Exclude filters do: globs
+// without a slash match the file name, the others the whole path
+type GlobSet struct {
+	rule compiledRule
+}
+
+// NewGlobSet compiles globs
+func NewGlobSet(globs ...string) (*GlobSet, error) {
+	g := &GlobSet{}
+	if err := g.rule.addGlobs(globs); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// Match reports whether a slash-separated path matches any of the globs
+func (g *GlobSet) Match(path string) bool {
+	return g.rule.matchesPath(path)
+}
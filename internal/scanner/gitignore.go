@@ -0,0 +1,619 @@
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ignorePattern is a single compiled gitignore pattern
+type ignorePattern struct {
+	re       *regexp.Regexp
+	negate   bool
+	dirOnly  bool
+	anchored bool   // contains a slash, so it matches the path below base
+	base     string // directory of the .gitignore, relative to the repo root
+}
+
+// matches reports whether the pattern applies to a repo-relative path
+func (p ignorePattern) matches(path string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+
+	rel := path
+	if p.base != "" {
+		if !strings.HasPrefix(path, p.base+"/") {
+			return false
+		}
+		rel = path[len(p.base)+1:]
+	}
+
+	if !p.anchored {
+		if i := strings.LastIndex(rel, "/"); i >= 0 {
+			rel = rel[i+1:]
+		}
+	}
+
+	return p.re.MatchString(rel)
+}
+
+// parseIgnoreFile reads gitignore patterns from a file. A missing file
+// yields no patterns.
+func parseIgnoreFile(path, base string) []ignorePattern {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+
+	var patterns []ignorePattern
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		if p, ok := parseIgnoreLine(scanner.Text(), base); ok {
+			patterns = append(patterns, p)
+		}
+	}
+	return patterns
+}
+
+// parseIgnoreLine compiles one line of a gitignore file
+func parseIgnoreLine(line, base string) (ignorePattern, bool) {
+	line = strings.TrimSuffix(line, "\r")
+	line = trimUnescapedSpaces(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignorePattern{}, false
+	}
+
+	p := ignorePattern{base: base}
+	if strings.HasPrefix(line, "!") {
+		p.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+		line = line[1:]
+	}
+
+	if strings.HasSuffix(line, "/") {
+		p.dirOnly = true
+		line = strings.TrimSuffix(line, "/")
+	}
+
+	if strings.Contains(line, "/") {
+		p.anchored = true
+		line = strings.TrimPrefix(line, "/")
+	}
+
+	if line == "" {
+		return ignorePattern{}, false
+	}
+
+	re, err := regexp.Compile(ignoreGlobToRegexp(line))
+	if err != nil {
+		return ignorePattern{}, false // git silently ignores broken patterns too
+	}
+	p.re = re
+	return p, true
+}
+
+// trimUnescapedSpaces removes trailing spaces unless quoted with a backslash
+func trimUnescapedSpaces(line string) string {
+	for strings.HasSuffix(line, " ") {
+		trimmed := line[:len(line)-1]
+		if strings.HasSuffix(trimmed, `\`) && !strings.HasSuffix(trimmed, `\\`) {
+			break
+		}
+		line = trimmed
+	}
+	return line
+}
+
+// ignoreGlobToRegexp converts a gitignore glob to an anchored regexp using
+// fnmatch(3) FNM_PATHNAME semantics plus the special "**" forms
+func ignoreGlobToRegexp(glob string) string {
+	var b strings.Builder
+	b.WriteString("^")
+
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case c == '*' && strings.HasPrefix(glob[i:], "**"):
+			leading := i == 0 || glob[i-1] == '/'
+			rest := glob[i+2:]
+			switch {
+			case leading && strings.HasPrefix(rest, "/"):
+				// "**/" matches zero or more directories
+				b.WriteString("(?:.*/)?")
+				i += 2
+			case leading && rest == "":
+				// trailing "/**" matches everything inside
+				b.WriteString(".*")
+				i++
+			default:
+				// any other run of asterisks is a plain "*"
+				b.WriteString("[^/]*")
+				for i+1 < len(glob) && glob[i+1] == '*' {
+					i++
+				}
+			}
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '\\' && i+1 < len(glob):
+			i++
+			b.WriteString(regexp.QuoteMeta(string(glob[i])))
+		case c == '[':
+			if class, n, ok := parseBracket(glob[i:]); ok {
+				b.WriteString(class)
+				i += n - 1
+			} else {
+				b.WriteString(`\[`)
+			}
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	b.WriteString("$")
+	return b.String()
+}
+
+// parseBracket converts a glob character class starting at s[0] == '['.
+// It returns the regexp class and the number of glob bytes consumed.
+func parseBracket(s string) (string, int, bool) {
+	i := 1
+	var b strings.Builder
+	b.WriteString("[")
+	if i < len(s) && (s[i] == '!' || s[i] == '^') {
+		b.WriteString("^")
+		i++
+	}
+	// A leading ']' is a literal member of the class
+	if i < len(s) && s[i] == ']' {
+		b.WriteString(`\]`)
+		i++
+	}
+	for ; i < len(s); i++ {
+		switch c := s[i]; c {
+		case ']':
+			b.WriteString("]")
+			return b.String(), i + 1, true
+		case '\\':
+			if i+1 < len(s) {
+				i++
+				b.WriteString(regexp.QuoteMeta(string(s[i])))
+			}
+		case '[':
+			// POSIX classes such as [:alpha:] pass through unchanged
+			if end := strings.Index(s[i:], ":]"); strings.HasPrefix(s[i:], "[:") && end > 0 {
+				b.WriteString(s[i : i+end+2])
+				i += end + 1
+			} else {
+				b.WriteString(`\[`)
+			}
+		case '/':
+			return "", 0, false // classes never match a slash
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", 0, false
+}
+
+// gitDirs locates the git directory and the common directory (shared by
+// worktrees) for a repository root
+func gitDirs(root string) (gitDir, commonDir string, err error) {
+	dotGit := filepath.Join(root, ".git")
+	info, err := os.Stat(dotGit)
+	if err != nil {
+		return "", "", err
+	}
+
+	gitDir = dotGit
+	if !info.IsDir() {
+		// Worktrees and submodules use a "gitdir: <path>" file
+		data, err := os.ReadFile(dotGit)
+		if err != nil {
+			return "", "", err
+		}
+		line := strings.TrimSpace(string(data))
+		if !strings.HasPrefix(line, "gitdir: ") {
+			return "", "", fmt.Errorf("invalid .git file: %s", dotGit)
+		}
+		gitDir = strings.TrimPrefix(line, "gitdir: ")
+		if !filepath.IsAbs(gitDir) {
+			gitDir = filepath.Join(root, gitDir)
+		}
+	}
+
+	commonDir = gitDir
+	if data, err := os.ReadFile(filepath.Join(gitDir, "commondir")); err == nil {
+		commonDir = strings.TrimSpace(string(data))
+		if !filepath.IsAbs(commonDir) {
+			commonDir = filepath.Join(gitDir, commonDir)
+		}
+	}
+
+	return gitDir, commonDir, nil
+}
+
+// findRepoRoot walks up from path to the directory containing .git
+func findRepoRoot(path string) (string, bool) {
+	for dir := path; ; {
+		if _, err := os.Lstat(filepath.Join(dir, ".git")); err == nil {
+			return dir, true
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", false
+		}
+		dir = parent
+	}
+}
+
+// readIndexPaths returns the paths tracked in a git index file. Index
+// versions 2, 3 and 4 are supported.
+func readIndexPaths(indexPath string, hashSize int) (map[string]bool, error) {
+	tracked := make(map[string]bool)
+
+	data, err := os.ReadFile(indexPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return tracked, nil // nothing staged yet
+		}
+		return nil, err
+	}
+
+	if len(data) < 12 || string(data[:4]) != "DIRC" {
+		return nil, fmt.Errorf("invalid index file: %s", indexPath)
+	}
+	version := binary.BigEndian.Uint32(data[4:8])
+	if version < 2 || version > 4 {
+		return nil, fmt.Errorf("unsupported index version %d", version)
+	}
+	count := binary.BigEndian.Uint32(data[8:12])
+
+	// ctime, mtime, dev, ino, mode, uid, gid, size, hash, flags
+	fixedSize := 40 + hashSize + 2
+	pos := 12
+	prev := ""
+
+	for n := uint32(0); n < count; n++ {
+		start := pos
+		if pos+fixedSize > len(data) {
+			return nil, fmt.Errorf("truncated index file: %s", indexPath)
+		}
+		flags := binary.BigEndian.Uint16(data[pos+40+hashSize:])
+		pos += fixedSize
+		if version >= 3 && flags&0x4000 != 0 {
+			pos += 2 // extended flags
+		}
+
+		var path string
+		if version == 4 {
+			strip, used := indexVarint(data[pos:])
+			if used == 0 || int(strip) > len(prev) {
+				return nil, fmt.Errorf("corrupt index file: %s", indexPath)
+			}
+			pos += used
+			end := bytes.IndexByte(data[pos:], 0)
+			if end < 0 {
+				return nil, fmt.Errorf("truncated index file: %s", indexPath)
+			}
+			path = prev[:len(prev)-int(strip)] + string(data[pos:pos+end])
+			pos += end + 1
+		} else {
+			end := bytes.IndexByte(data[pos:], 0)
+			if end < 0 {
+				return nil, fmt.Errorf("truncated index file: %s", indexPath)
+			}
+			path = string(data[pos : pos+end])
+			// Entries are NUL-padded to a multiple of eight bytes
+			pos = start + (pos+end-start+8)&^7
+		}
+
+		tracked[strings.TrimSuffix(path, "/")] = true
+		prev = path
+	}
+
+	return tracked, nil
+}
+
+// indexVarint decodes the offset varint used by index version 4
+func indexVarint(data []byte) (uint64, int) {
+	if len(data) == 0 {
+		return 0, 0
+	}
+	c := data[0]
+	val := uint64(c & 0x7f)
+	i := 1
+	for c&0x80 != 0 {
+		if i >= len(data) {
+			return 0, 0
+		}
+		c = data[i]
+		i++
+		val = ((val + 1) << 7) | uint64(c&0x7f)
+	}
+	return val, i
+}
+
+// gitConfigValue returns the last value of a key across git config files
+func gitConfigValue(files []string, section, key string) string {
+	var value string
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+
+		current := ""
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || line[0] == '#' || line[0] == ';' {
+				continue
+			}
+			if strings.HasPrefix(line, "[") {
+				end := strings.Index(line, "]")
+				if end > 0 {
+					current = strings.ToLower(strings.TrimSpace(line[1:end]))
+					line = strings.TrimSpace(line[end+1:])
+				}
+				if line == "" {
+					continue
+				}
+			}
+			if current != section {
+				continue
+			}
+
+			k, v, ok := strings.Cut(line, "=")
+			if !ok || !strings.EqualFold(strings.TrimSpace(k), key) {
+				continue
+			}
+			value = parseConfigValue(v)
+		}
+	}
+	return value
+}
+
+// parseConfigValue strips quotes and trailing comments from a config value
+func parseConfigValue(v string) string {
+	var b strings.Builder
+	inQuote := false
+	for i := 0; i < len(v); i++ {
+		c := v[i]
+		switch {
+		case c == '"':
+			inQuote = !inQuote
+		case c == '\\' && i+1 < len(v):
+			i++
+			b.WriteByte(v[i])
+		case (c == '#' || c == ';') && !inQuote:
+			return strings.TrimSpace(b.String())
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// globalExcludesFile returns the path of core.excludesFile, falling back to
+// git's default of $XDG_CONFIG_HOME/git/ignore
+func globalExcludesFile(commonDir string) string {
+	home, _ := os.UserHomeDir()
+	xdg := os.Getenv("XDG_CONFIG_HOME")
+	if xdg == "" && home != "" {
+		xdg = filepath.Join(home, ".config")
+	}
+
+	// Same precedence as git: system, XDG, user, then repository config
+	files := []string{"/etc/gitconfig"}
+	if xdg != "" {
+		files = append(files, filepath.Join(xdg, "git", "config"))
+	}
+	if home != "" {
+		files = append(files, filepath.Join(home, ".gitconfig"))
+	}
+	files = append(files, filepath.Join(commonDir, "config"))
+
+	path := gitConfigValue(files, "core", "excludesfile")
+	if path == "" {
+		if xdg == "" {
+			return ""
+		}
+		return filepath.Join(xdg, "git", "ignore")
+	}
+	if strings.HasPrefix(path, "~/") && home != "" {
+		path = filepath.Join(home, path[2:])
+	}
+	return path
+}
+
+// nativeIgnore lists ignored files without the git binary
+type nativeIgnore struct {
+	root    string
+	tracked map[string]bool
+	dirs    map[string]bool // directories containing tracked files
+}
+
+// getNativeIgnoredFiles returns the paths git would report as ignored,
+// relative to the repository root. Like `git status --ignored`, fully
+// ignored directories are reported once, without a trailing slash.
+func getNativeIgnoredFiles(repoPath string) ([]string, error) {
+	root, ok := findRepoRoot(repoPath)
+	if !ok {
+		return nil, fmt.Errorf("not a git repository: %s", repoPath)
+	}
+
+	gitDir, commonDir, err := gitDirs(root)
+	if err != nil {
+		return nil, err
+	}
+
+	tracked, err := readRepoIndex(gitDir, commonDir)
+	if err != nil {
+		return nil, err
+	}
+
+	n := &nativeIgnore{
+		root:    root,
+		tracked: tracked,
+		dirs:    make(map[string]bool),
+	}
+	for path := range tracked {
+		for dir := filepath.Dir(filepath.FromSlash(path)); dir != "."; dir = filepath.Dir(dir) {
+			n.dirs[filepath.ToSlash(dir)] = true
+		}
+	}
+
+	// Lowest precedence first: later patterns override earlier ones
+	var patterns []ignorePattern
+	if excludes := globalExcludesFile(commonDir); excludes != "" {
+		patterns = append(patterns, parseIgnoreFile(excludes, "")...)
+	}
+	patterns = append(patterns, parseIgnoreFile(filepath.Join(commonDir, "info", "exclude"), "")...)
+
+	var ignored []string
+	_, err = n.walk("", patterns, false, &ignored)
+	return ignored, err
+}
+
+// getNativeTrackedFiles returns the files tracked in the index below
+// repoPath, relative to repoPath like `git ls-files`
+func getNativeTrackedFiles(repoPath string) ([]string, error) {
+	root, ok := findRepoRoot(repoPath)
+	if !ok {
+		return nil, fmt.Errorf("not a git repository: %s", repoPath)
+	}
+
+	gitDir, commonDir, err := gitDirs(root)
+	if err != nil {
+		return nil, err
+	}
+
+	tracked, err := readRepoIndex(gitDir, commonDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for path := range tracked {
+		rel, err := filepath.Rel(repoPath, filepath.Join(root, filepath.FromSlash(path)))
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue // outside the scanned directory
+		}
+		paths = append(paths, filepath.ToSlash(rel))
+	}
+	sort.Strings(paths)
+
+	return paths, nil
+}
+
+// readRepoIndex reads the tracked paths of a repository's index
+func readRepoIndex(gitDir, commonDir string) (map[string]bool, error) {
+	hashSize := 20
+	if strings.EqualFold(gitConfigValue([]string{filepath.Join(commonDir, "config")}, "extensions", "objectformat"), "sha256") {
+		hashSize = 32
+	}
+	return readIndexPaths(filepath.Join(gitDir, "index"), hashSize)
+}
+
+// walk visits a repo-relative directory, collecting ignored paths. It
+// reports whether everything below dir is ignored, in which case git lists
+// an untracked directory once instead of its files.
+func (n *nativeIgnore) walk(dir string, patterns []ignorePattern, excluded bool, out *[]string) (bool, error) {
+	absDir := filepath.Join(n.root, filepath.FromSlash(dir))
+	if !excluded {
+		patterns = append(patterns[:len(patterns):len(patterns)], parseIgnoreFile(filepath.Join(absDir, ".gitignore"), dir)...)
+	}
+
+	entries, err := os.ReadDir(absDir)
+	if err != nil {
+		if dir == "" {
+			return false, err
+		}
+		return false, nil // unreadable subdirectories are skipped, like git does
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	allIgnored := true
+	for _, entry := range entries {
+		if entry.Name() == ".git" {
+			continue
+		}
+
+		path := entry.Name()
+		if dir != "" {
+			path = dir + "/" + entry.Name()
+		}
+
+		if !entry.IsDir() {
+			if !n.tracked[path] && (excluded || isIgnored(patterns, path, false)) {
+				*out = append(*out, path)
+			} else {
+				allIgnored = false
+			}
+			continue
+		}
+
+		ignored := excluded || isIgnored(patterns, path, true)
+
+		// Nested repositories and submodules are never descended into
+		if _, err := os.Lstat(filepath.Join(absDir, entry.Name(), ".git")); err == nil {
+			if ignored && !n.tracked[path] {
+				*out = append(*out, path)
+			} else {
+				allIgnored = false
+			}
+			continue
+		}
+
+		if ignored && !n.dirs[path] {
+			*out = append(*out, path)
+			continue
+		}
+
+		if n.dirs[path] {
+			// Directories with tracked files are never listed whole
+			allIgnored = false
+			if _, err := n.walk(path, patterns, ignored, out); err != nil {
+				return false, err
+			}
+			continue
+		}
+
+		// An untracked directory holding only ignored files is listed
+		// once; empty ones are not listed at all
+		var sub []string
+		subIgnored, err := n.walk(path, patterns, ignored, &sub)
+		if err != nil {
+			return false, err
+		}
+		if subIgnored && len(sub) > 0 {
+			*out = append(*out, path)
+		} else {
+			*out = append(*out, sub...)
+		}
+		allIgnored = allIgnored && subIgnored
+	}
+
+	return allIgnored, nil
+}
+
+// isIgnored applies patterns in order; the last matching pattern decides
+func isIgnored(patterns []ignorePattern, path string, isDir bool) bool {
+	ignored := false
+	for _, p := range patterns {
+		if p.matches(path, isDir) {
+			ignored = !p.negate
+		}
+	}
+	return ignored
+}
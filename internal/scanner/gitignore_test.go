@@ -0,0 +1,235 @@
+package scanner
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// ignoreCase is a repository layout and the paths `git status --ignored`
+// reports for it
+type ignoreCase struct {
+	name    string
+	files   map[string]string // path -> content, including .gitignore files
+	tracked []string          // added to the index with git add -f
+	want    []string
+}
+
+var ignoreCorpus = []ignoreCase{
+	{
+		name: "negation",
+		files: map[string]string{
+			".gitignore": "*.log\n!keep.log\n",
+			"a.log":      "",
+			"keep.log":   "",
+			"b.txt":      "",
+		},
+		want: []string{"a.log"},
+	},
+	{
+		name: "negation inside an excluded directory",
+		files: map[string]string{
+			".gitignore":     "build/\n!build/keep.txt\n",
+			"build/a.o":      "",
+			"build/keep.txt": "",
+		},
+		want: []string{"build"},
+	},
+	{
+		name: "double star",
+		files: map[string]string{
+			".gitignore":       "**/secret.env\nlogs/**\na/**/b.txt\n",
+			"secret.env":       "",
+			"x/secret.env":     "",
+			"x/keep.txt":       "",
+			"logs/one/two.txt": "",
+			"a/b.txt":          "",
+			"a/x/y/b.txt":      "",
+			"a/x/y/c.txt":      "",
+			"a/c.txt":          "",
+		},
+		want: []string{"a/b.txt", "a/x/y/b.txt", "logs", "secret.env", "x/secret.env"},
+	},
+	{
+		name: "anchoring",
+		files: map[string]string{
+			".gitignore":     "/root.txt\nsub/file.txt\nany.txt\n",
+			"root.txt":       "",
+			"d/root.txt":     "",
+			"sub/file.txt":   "",
+			"d/sub/file.txt": "",
+			"any.txt":        "",
+			"d/any.txt":      "",
+			"sub/keep.txt":   "",
+		},
+		want: []string{"any.txt", "d/any.txt", "root.txt", "sub/file.txt"},
+	},
+	{
+		name: "directory only",
+		files: map[string]string{
+			".gitignore":  "cache/\n",
+			"cache/x":     "",
+			"d/cache":     "",
+			"d/cache.txt": "",
+		},
+		want: []string{"cache"},
+	},
+	{
+		name: "escaped hash and bang",
+		files: map[string]string{
+			".gitignore":  "\\#notcomment\n\\!bang\n#comment\n",
+			"#notcomment": "",
+			"!bang":       "",
+			"comment":     "",
+		},
+		want: []string{"!bang", "#notcomment"},
+	},
+	{
+		name: "trailing spaces",
+		files: map[string]string{
+			".gitignore": "spaced.txt   \nkept\\ \n",
+			"spaced.txt": "",
+			"kept ":      "",
+			"kept":       "",
+		},
+		want: []string{"kept ", "spaced.txt"},
+	},
+	{
+		name: "nested gitignore",
+		files: map[string]string{
+			".gitignore":     "*.tmp\n",
+			"sub/.gitignore": "!keep.tmp\nlocal.txt\n",
+			"a.tmp":          "",
+			"local.txt":      "",
+			"sub/keep.tmp":   "",
+			"sub/other.tmp":  "",
+			"sub/local.txt":  "",
+		},
+		want: []string{"a.tmp", "sub/local.txt", "sub/other.tmp"},
+	},
+	{
+		name: "info exclude",
+		files: map[string]string{
+			".git/info/exclude": "*.secret\n",
+			".gitignore":        "!b.secret\n",
+			"a.secret":          "",
+			"b.secret":          "",
+			"c.txt":             "",
+		},
+		want: []string{"a.secret"},
+	},
+	{
+		name: "untracked directories of ignored files",
+		files: map[string]string{
+			".gitignore":     "*.log\n",
+			"foo/a.log":      "",
+			"foo/bar/b.log":  "",
+			"deep/a/b/c.log": "",
+			"mixed/a.log":    "",
+			"mixed/b.txt":    "",
+			"trk/a.log":      "",
+			"trk/t.txt":      "",
+		},
+		tracked: []string{"trk/t.txt"},
+		want:    []string{"deep", "foo", "mixed/a.log", "trk/a.log"},
+	},
+	{
+		name: "tracked but ignored",
+		files: map[string]string{
+			".gitignore":         "*.env\nconfig/\n",
+			".env":               "",
+			"prod.env":           "",
+			"config/tracked.yml": "",
+			"config/local.yml":   "",
+		},
+		tracked: []string{"prod.env", "config/tracked.yml"},
+		want:    []string{".env", "config/local.yml"},
+	},
+}
+
+// makeIgnoreRepo lays out a test repository. Without git, cases that need
+// tracked files are skipped.
+func makeIgnoreRepo(t *testing.T, tc ignoreCase, haveGit bool) string {
+	t.Helper()
+	root := t.TempDir()
+
+	if haveGit {
+		runGit(t, root, "init", "-q")
+	} else {
+		if len(tc.tracked) > 0 {
+			t.Skip("git is needed to create an index")
+		}
+		if err := os.MkdirAll(filepath.Join(root, ".git"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for path, content := range tc.files {
+		full := filepath.Join(root, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(tc.tracked) > 0 {
+		runGit(t, root, append([]string{"add", "-f", "--"}, tc.tracked...)...)
+	}
+	return root
+}
+
+func runGit(t *testing.T, dir string, args ...string) {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git %v: %v\n%s", args, err, out)
+	}
+}
+
+func sorted(paths []string) []string {
+	paths = append([]string{}, paths...)
+	sort.Strings(paths)
+	return paths
+}
+
+func TestNativeIgnoreMatchesGit(t *testing.T) {
+	_, err := exec.LookPath("git")
+	haveGit := err == nil
+
+	// Keep the user's global excludes and config out of the corpus
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, ".config"))
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+
+	for _, tc := range ignoreCorpus {
+		t.Run(tc.name, func(t *testing.T) {
+			root := makeIgnoreRepo(t, tc, haveGit)
+
+			native, err := getNativeIgnoredFiles(root)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := sorted(native); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("native backend = %q, want %q", got, tc.want)
+			}
+
+			if !haveGit {
+				return
+			}
+			git, err := getGitIgnoredFiles(root)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := sorted(git); !reflect.DeepEqual(got, sorted(native)) {
+				t.Errorf("git status --ignored = %q, native backend = %q", got, sorted(native))
+			}
+		})
+	}
+}
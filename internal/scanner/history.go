@@ -0,0 +1,206 @@
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HistoryInfo describes where a secret file was found in git history
+type HistoryInfo struct {
+	Commit       string    `json:"commit"`        // oldest commit that added this blob
+	Blob         string    `json:"blob"`          // object ID of the file content
+	AuthorDate   time.Time `json:"author_date"`   // author date of that commit
+	StillIgnored bool      `json:"still_ignored"` // the path is ignored by today's rules
+}
+
+// historyBlob is one path/content pair found while walking commits
+type historyBlob struct {
+	path string
+	info HistoryInfo
+}
+
+// scanHistory walks every commit reachable from any ref and adds the blobs
+// whose paths are classified as secrets. Each distinct blob is reported
+// once, at the oldest commit that introduced it. This requires the git
+// binary.
+func (s *Scanner) scanHistory(result *ScanResult, absPath string) error {
+	if s.resolveBackend() != BackendGit {
+		return fmt.Errorf("history scans require the git backend")
+	}
+	if !isGitRepo(absPath) {
+		return nil
+	}
+
+	blobs, err := getHistoryBlobs(absPath)
+	if err != nil {
+		return err
+	}
+
+	var secrets []historyBlob
+	for _, blob := range blobs {
+		if s.ExcludeDeps && isInDepsDir(blob.path) {
+			continue
+		}
+		if category, secret := s.Rules.Classify(blob.path); secret && s.selects(blob.path, category, 0) { // sizes are checked below
+			secrets = append(secrets, blob)
+		}
+	}
+	if len(secrets) == 0 {
+		return nil
+	}
+
+	var paths, ids []string
+	for _, blob := range secrets {
+		paths = append(paths, blob.path)
+		ids = append(ids, blob.info.Blob)
+	}
+
+	ignored, err := getGitCheckIgnored(absPath, paths)
+	if err != nil {
+		return err
+	}
+	sizes, err := getGitBlobSizes(absPath, ids)
+	if err != nil {
+		return err
+	}
+
+	for _, blob := range secrets {
+		if s.MaxSize > 0 && sizes[blob.info.Blob] > s.MaxSize {
+			continue
+		}
+
+		info := blob.info
+		info.StillIgnored = ignored[blob.path]
+
+		file := IgnoredFile{
+			Path:     blob.path,
+			Size:     sizes[info.Blob],
+			IsSecret: true,
+			History:  &info,
+		}
+		file.Category, _ = s.Rules.Classify(blob.path)
+		file.Confidence = s.confidence(file)
+
+		result.IgnoredFiles = append(result.IgnoredFiles, file)
+		result.TotalSize += file.Size
+		result.SecretCount++
+	}
+
+	return nil
+}
+
+// getHistoryBlobs lists every added or modified blob below repoPath in the
+// history of all refs, keeping the oldest commit for each path and blob.
+// Paths are relative to repoPath, like those of the working tree scan.
+func getHistoryBlobs(repoPath string) ([]historyBlob, error) {
+	cmd := exec.Command("git", "log", "--all", "--no-renames", "--diff-filter=AM", "--relative",
+		"--raw", "--no-abbrev", "-z", "--pretty=format:%x1e%H%x1f%aI")
+	cmd.Dir = repoPath
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]int) // path + blob -> index in blobs
+	var blobs []historyBlob
+
+	// git log lists newest commits first, so later records are older
+	for _, record := range strings.Split(string(output), "\x1e") {
+		header, body, ok := strings.Cut(record, "\n")
+		if !ok {
+			continue
+		}
+		commit, date, ok := strings.Cut(header, "\x1f")
+		if !ok {
+			continue
+		}
+		authorDate, _ := time.Parse(time.RFC3339, date)
+
+		// Raw entries alternate ":<modes> <old> <new> <status>" and a path
+		fields := strings.Split(body, "\x00")
+		for i := 0; i+1 < len(fields); i += 2 {
+			meta, path := fields[i], fields[i+1]
+			parts := strings.Fields(meta)
+			if !strings.HasPrefix(meta, ":") || len(parts) < 5 {
+				continue
+			}
+
+			// Skip gitlinks (submodules) and symlinks
+			if !strings.HasPrefix(parts[1], "100") {
+				continue
+			}
+
+			blob := historyBlob{
+				path: path,
+				info: HistoryInfo{
+					Commit:     commit,
+					Blob:       parts[3],
+					AuthorDate: authorDate,
+				},
+			}
+
+			key := path + "\x00" + blob.info.Blob
+			if idx, ok := seen[key]; ok {
+				blobs[idx] = blob
+				continue
+			}
+			seen[key] = len(blobs)
+			blobs = append(blobs, blob)
+		}
+	}
+
+	return blobs, nil
+}
+
+// getGitCheckIgnored returns which paths today's ignore rules match,
+// whether or not they are tracked
+func getGitCheckIgnored(repoPath string, paths []string) (map[string]bool, error) {
+	cmd := exec.Command("git", "check-ignore", "--no-index", "-z", "--stdin")
+	cmd.Dir = repoPath
+	cmd.Stdin = strings.NewReader(strings.Join(paths, "\x00") + "\x00")
+	output, err := cmd.Output()
+	if err != nil {
+		// Exit status 1 means none of the paths are ignored
+		if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != 1 {
+			return nil, err
+		}
+	}
+
+	ignored := make(map[string]bool)
+	for _, path := range strings.Split(string(output), "\x00") {
+		if path != "" {
+			ignored[path] = true
+		}
+	}
+	return ignored, nil
+}
+
+// getGitBlobSizes looks up the size of each blob
+func getGitBlobSizes(repoPath string, ids []string) (map[string]int64, error) {
+	cmd := exec.Command("git", "cat-file", "--batch-check=%(objectname) %(objectsize)")
+	cmd.Dir = repoPath
+	cmd.Stdin = strings.NewReader(strings.Join(ids, "\n") + "\n")
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git cat-file: %w", err)
+	}
+
+	sizes := make(map[string]int64)
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		id, size, ok := strings.Cut(scanner.Text(), " ")
+		if !ok {
+			continue
+		}
+		// Unknown objects are reported as "<id> missing"
+		if n, err := strconv.ParseInt(size, 10, 64); err == nil {
+			sizes[id] = n
+		}
+	}
+	return sizes, scanner.Err()
+}
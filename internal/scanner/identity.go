@@ -0,0 +1,139 @@
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// RepoIdentity identifies a repository independently of where it is cloned
+type RepoIdentity struct {
+	Remotes    []string // remote URLs as configured
+	RootCommit string   // oldest root commit of HEAD, empty if unknown
+}
+
+// remoteSectionPattern matches a [remote "name"] config section header
+var remoteSectionPattern = regexp.MustCompile(`^\[\s*remote\s+"[^"]*"\s*\]`)
+
+// Identify returns the remote URLs and root commit of a repository. The
+// root commit needs the git backend; the native backend only reads remotes.
+func (s *Scanner) Identify(repoPath string) RepoIdentity {
+	var id RepoIdentity
+
+	if s.resolveBackend() == BackendGit {
+		cmd := exec.Command("git", "config", "--get-regexp", `^remote\..*\.url$`)
+		cmd.Dir = repoPath
+		if output, err := cmd.Output(); err == nil {
+			for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+				if _, url, ok := strings.Cut(line, " "); ok {
+					id.Remotes = append(id.Remotes, url)
+				}
+			}
+		}
+
+		// Histories can have several roots; the last one listed is the oldest
+		cmd = exec.Command("git", "rev-list", "--max-parents=0", "HEAD")
+		cmd.Dir = repoPath
+		if output, err := cmd.Output(); err == nil {
+			roots := strings.Fields(string(output))
+			if len(roots) > 0 {
+				id.RootCommit = roots[len(roots)-1]
+			}
+		}
+	} else if _, commonDir, err := gitDirs(repoPath); err == nil {
+		id.Remotes = configRemoteURLs(filepath.Join(commonDir, "config"))
+	}
+
+	sort.Strings(id.Remotes)
+	return id
+}
+
+// configRemoteURLs reads the url of every remote from a git config file
+func configRemoteURLs(path string) []string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+
+	var urls []string
+	inRemote := false
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			inRemote = remoteSectionPattern.MatchString(line)
+			continue
+		}
+		if !inRemote {
+			continue
+		}
+		k, v, ok := strings.Cut(line, "=")
+		if ok && strings.EqualFold(strings.TrimSpace(k), "url") {
+			urls = append(urls, parseConfigValue(v))
+		}
+	}
+	return urls
+}
+
+// scpLikePattern matches "user@host:path" remotes
+var scpLikePattern = regexp.MustCompile(`^(?:[^@/]+@)?([^:/]+):(.+)$`)
+
+// NormalizeRemoteURL reduces a remote URL to a lowercase "host/path" so
+// that the SSH, HTTPS and git protocol forms of the same repository compare
+// equal
+func NormalizeRemoteURL(url string) string {
+	url = strings.TrimSpace(url)
+
+	if scheme, rest, ok := strings.Cut(url, "://"); ok {
+		if scheme == "file" {
+			return filepath.Clean(rest)
+		}
+		url = rest
+		// Drop credentials and port
+		if at := strings.LastIndex(strings.SplitN(url, "/", 2)[0], "@"); at >= 0 {
+			url = url[at+1:]
+		}
+		host, path, _ := strings.Cut(url, "/")
+		if h, _, ok := strings.Cut(host, ":"); ok {
+			host = h
+		}
+		url = host + "/" + path
+	} else if m := scpLikePattern.FindStringSubmatch(url); m != nil {
+		url = m[1] + "/" + m[2]
+	} else {
+		return filepath.Clean(url) // local path
+	}
+
+	url = strings.TrimSuffix(strings.TrimSuffix(url, "/"), ".git")
+	host, path, _ := strings.Cut(url, "/")
+	return strings.ToLower(host + "/" + strings.TrimPrefix(path, "/"))
+}
+
+// SameRepo reports whether two identities describe the same repository:
+// a shared remote, or the same root commit when either side has no remotes
+func (id RepoIdentity) SameRepo(other RepoIdentity) bool {
+	for _, a := range id.Remotes {
+		for _, b := range other.Remotes {
+			if NormalizeRemoteURL(a) == NormalizeRemoteURL(b) {
+				return true
+			}
+		}
+	}
+	if len(id.Remotes) > 0 && len(other.Remotes) > 0 {
+		return false
+	}
+	return id.RootCommit != "" && id.RootCommit == other.RootCommit
+}
+
+// IsZero reports whether nothing identifies the repository
+func (id RepoIdentity) IsZero() bool {
+	return len(id.Remotes) == 0 && id.RootCommit == ""
+}
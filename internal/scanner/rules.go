@@ -0,0 +1,286 @@
+package scanner
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/O6lvl4/igloc/internal/config"
+)
+
+// fallbackCategory is assigned to files no rule matches
+const fallbackCategory = "other"
+
+// Ruleset classifies files into categories
+type Ruleset struct {
+	rules      []compiledRule
+	icons      map[string]string
+	categories []string
+}
+
+type compiledRule struct {
+	category string
+	priority int
+	secret   bool
+	names    []*regexp.Regexp // matched against the base name
+	paths    []*regexp.Regexp // matched against the relative path
+}
+
+// defaultRules returns the built-in classification rules
+func defaultRules() []config.CategoryRule {
+	return []config.CategoryRule{
+		{
+			Name:     "env",
+			Icon:     "🔑",
+			Priority: 100,
+			Secret:   true,
+			Globs:    []string{".env", ".env.*", "env.*"},
+		},
+		{
+			Name:     "key",
+			Icon:     "🔐",
+			Priority: 90,
+			Secret:   true,
+			Globs: []string{
+				"*.pem", "*.key", "*.p12", "*.pfx", "*.keystore", "*.jks",
+				"id_rsa*", "id_dsa*", "id_ecdsa*", "id_ed25519*",
+			},
+			// Whole words only, so keyboard.json or monkey.yaml don't match
+			Regex: []string{`(?i)(^|/|[._-])(keys?|secrets?|credentials?|tokens?|passwords?|private|keystore|api_?keys?)([._-][^/]*)?$`},
+		},
+		{
+			Name:     "other",
+			Priority: 80,
+			Secret:   true,
+			Globs:    []string{".npmrc", ".netrc", ".pypirc", ".git-credentials"},
+			Regex:    []string{`(?i)(^|/|[._-])auth([._-][^/]*)?$`},
+		},
+		{
+			Name:     "config",
+			Icon:     "⚙️",
+			Priority: 70,
+			Regex:    []string{`(?i)(^|/)[^/]*(config|setting)[^/]*\.(json|ya?ml|toml|ini)$`},
+		},
+		{
+			Name:     "build",
+			Icon:     "📦",
+			Priority: 60,
+			Regex:    []string{`(^|/)(node_modules|dist|build|\.next|__pycache__|target|bin|obj)/`},
+		},
+		{
+			Name:     "cache",
+			Icon:     "💾",
+			Priority: 50,
+			Regex:    []string{`(?i)cache`},
+		},
+		{
+			Name:     "ide",
+			Icon:     "🖥️",
+			Priority: 40,
+			Regex:    []string{`^(\.idea|\.vscode|\.vs)/`},
+		},
+		{
+			Name: "other",
+			Icon: "📄",
+		},
+	}
+}
+
+// DefaultRuleset returns the built-in ruleset
+func DefaultRuleset() *Ruleset {
+	rs, err := NewRuleset(defaultRules())
+	if err != nil {
+		panic(err) // built-in rules always compile
+	}
+	return rs
+}
+
+// LoadRuleset builds the ruleset from rules.yaml merged with the built-in
+// rules. User rules win over built-in rules of the same priority.
+func LoadRuleset() (*Ruleset, error) {
+	cfg, err := config.LoadRules()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load rules: %w", err)
+	}
+	if cfg == nil {
+		return DefaultRuleset(), nil
+	}
+
+	rules := cfg.Categories
+	if !cfg.Replace {
+		rules = append(rules, defaultRules()...)
+	}
+
+	rs, err := NewRuleset(rules)
+	if err != nil {
+		path, _ := config.RulesFilePath()
+		return nil, fmt.Errorf("invalid rules in %s: %w", path, err)
+	}
+	return rs, nil
+}
+
+// NewRuleset compiles classification rules. Rules are tried by descending
+// priority, and earlier rules win ties.
+func NewRuleset(rules []config.CategoryRule) (*Ruleset, error) {
+	rs := &Ruleset{icons: make(map[string]string)}
+	seen := make(map[string]bool)
+
+	for _, rule := range rules {
+		if rule.Name == "" {
+			return nil, fmt.Errorf("rule without a name")
+		}
+
+		cr := compiledRule{
+			category: rule.Name,
+			priority: rule.Priority,
+			secret:   rule.Secret,
+		}
+		if err := cr.addGlobs(rule.Globs); err != nil {
+			return nil, fmt.Errorf("category %s: %w", rule.Name, err)
+		}
+		for _, expr := range rule.Regex {
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return nil, fmt.Errorf("category %s: regex %q: %w", rule.Name, expr, err)
+			}
+			cr.paths = append(cr.paths, re)
+		}
+		rs.rules = append(rs.rules, cr)
+
+		if _, ok := rs.icons[rule.Name]; !ok && rule.Icon != "" {
+			rs.icons[rule.Name] = rule.Icon
+		}
+		if !seen[rule.Name] {
+			seen[rule.Name] = true
+			rs.categories = append(rs.categories, rule.Name)
+		}
+	}
+
+	if !seen[fallbackCategory] {
+		rs.categories = append(rs.categories, fallbackCategory)
+	}
+
+	sort.SliceStable(rs.rules, func(i, j int) bool {
+		return rs.rules[i].priority > rs.rules[j].priority
+	})
+
+	return rs, nil
+}
+
+// Classify returns the category of a file and whether it likely holds secrets
+func (rs *Ruleset) Classify(path string) (category string, secret bool) {
+	name := path
+	if i := strings.LastIndex(path, "/"); i >= 0 {
+		name = path[i+1:]
+	}
+
+	for _, rule := range rs.rules {
+		if rule.matches(path, name) {
+			return rule.category, rule.secret
+		}
+	}
+	return fallbackCategory, false
+}
+
+// Icon returns the display icon for a category
+func (rs *Ruleset) Icon(category string) string {
+	if icon, ok := rs.icons[category]; ok {
+		return icon
+	}
+	return "📄"
+}
+
+// Categories returns all known category names in declaration order
+func (rs *Ruleset) Categories() []string {
+	return rs.categories
+}
+
+// HasCategory reports whether a category is declared by any rule
+func (rs *Ruleset) HasCategory(category string) bool {
+	for _, c := range rs.categories {
+		if c == category {
+			return true
+		}
+	}
+	return false
+}
+
+// addGlobs compiles globs; those without a slash match the file name,
+// the others the whole path
+func (r *compiledRule) addGlobs(globs []string) error {
+	for _, glob := range globs {
+		re, err := globToRegexp(glob)
+		if err != nil {
+			return fmt.Errorf("glob %q: %w", glob, err)
+		}
+		if strings.Contains(glob, "/") {
+			r.paths = append(r.paths, re)
+		} else {
+			r.names = append(r.names, re)
+		}
+	}
+	return nil
+}
+
+// matchesPath splits the file name off path and calls matches
+func (r compiledRule) matchesPath(path string) bool {
+	name := path
+	if i := strings.LastIndex(path, "/"); i >= 0 {
+		name = path[i+1:]
+	}
+	return r.matches(path, name)
+}
+
+func (r compiledRule) matches(path, name string) bool {
+	for _, re := range r.names {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	for _, re := range r.paths {
+		if re.MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// globToRegexp converts a case-insensitive glob to an anchored regexp.
+// "*" and "?" stay within a path segment, "**" spans directories.
+func globToRegexp(glob string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("(?i)^")
+
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			b.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated character class")
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i += end + 1
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
@@ -1,43 +1,118 @@
 package scanner
 
 import (
-	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"math"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 
 	"github.com/O6lvl4/igloc/internal/config"
 )
 
 // IgnoredFile represents a file that is ignored by .gitignore
 type IgnoredFile struct {
-	Path       string
-	Size       int64
-	IsSecret   bool   // likely contains secrets (.env, credentials, etc.)
-	Category   string // env, key, config, cache, build, other
+	Path       string    `json:"path"`
+	Size       int64     `json:"size"`
+	IsSecret   bool      `json:"is_secret"`          // likely contains secrets (.env, credentials, etc.)
+	Category   string    `json:"category"`           // env, key, config, cache, build, ide, other or a custom category
+	Findings   []Finding `json:"findings,omitempty"` // content rules that matched inside the file
+	Confidence float64   `json:"confidence"`         // 0-1, how likely the file holds secrets
+
+	// Set for tracked files reported by an exposed scan
+	Exposed       bool   `json:"exposed,omitempty"`
+	GitignoreLine string `json:"gitignore_line,omitempty"` // line that ignores the file
+	FixCommand    string `json:"fix_command,omitempty"`    // command that stops tracking it
+
+	// Set for blobs reported by a history scan
+	History *HistoryInfo `json:"history,omitempty"`
+}
+
+// Fingerprint identifies what was found in a file, independent of the exact
+// secret values: the category plus the set of content rules that matched.
+// It changes when a new kind of secret appears in the file.
+func (f IgnoredFile) Fingerprint() string {
+	seen := make(map[string]bool)
+	parts := []string{f.Category}
+	for _, finding := range f.Findings {
+		if !seen[finding.RuleID] {
+			seen[finding.RuleID] = true
+			parts = append(parts, finding.RuleID)
+		}
+	}
+	sort.Strings(parts[1:])
+
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(sum[:8])
 }
 
 // ScanResult contains the results of scanning a directory
 type ScanResult struct {
-	RootPath     string
-	IgnoredFiles []IgnoredFile
-	TotalSize    int64
-	SecretCount  int
+	RootPath     string        `json:"root_path"`
+	IgnoredFiles []IgnoredFile `json:"ignored_files"`
+	TotalSize    int64         `json:"total_size"`
+	SecretCount  int           `json:"secret_count"`
+}
+
+// Backend selects how ignored files are listed
+type Backend string
+
+const (
+	BackendAuto   Backend = "auto"   // git when installed, native otherwise
+	BackendGit    Backend = "git"    // git status --ignored
+	BackendNative Backend = "native" // in-process gitignore matcher
+)
+
+// ParseBackend validates a backend name
+func ParseBackend(name string) (Backend, error) {
+	switch b := Backend(name); b {
+	case BackendAuto, BackendGit, BackendNative:
+		return b, nil
+	default:
+		return "", fmt.Errorf("unknown backend: %s (use auto, git or native)", name)
+	}
 }
 
 // Scanner scans directories for gitignored files
 type Scanner struct {
-	ShowAll     bool     // show all ignored files, not just secrets
-	Categories  []string // filter by categories
-	ExcludeDeps bool     // exclude node_modules, vendor, etc.
+	ShowAll        bool     // show all ignored files, not just secrets
+	Categories     []string // only report files in these categories
+	Include        []string // only report files matching one of these globs
+	Exclude        []string // skip files matching any of these globs
+	MaxSize        int64    // skip files larger than this; 0 for no limit
+	Repos          []string // with ScanRecursive, only scan repos matching one of these globs
+	ExcludeDeps    bool     // exclude node_modules, vendor, etc.
+	InspectContent bool     // look inside files for known credential formats
+	MaxContentSize int64    // skip content inspection for larger files
+	DetectEntropy  bool     // flag high-entropy values in inspected files
+	Entropy        EntropyOptions
+	Rules          *Ruleset // classifies files into categories
+	Backend        Backend  // how ignored files are listed
+	Exposed        bool     // report tracked files that look like secrets instead
+	History        bool     // report secret files found anywhere in git history instead
+
+	filterOnce sync.Once
+	filter     *fileFilter
+	filterErr  error
 }
 
 // NewScanner creates a new scanner
 func NewScanner() *Scanner {
 	return &Scanner{
-		ShowAll:     false,
-		ExcludeDeps: true, // exclude deps by default
+		ShowAll:        false,
+		ExcludeDeps:    true, // exclude deps by default
+		InspectContent: true,
+		MaxContentSize: DefaultMaxContentSize,
+		DetectEntropy:  true,
+		Entropy:        DefaultEntropyOptions(),
+		Rules:          DefaultRuleset(),
+		Backend:        BackendAuto,
 	}
 }
 
@@ -53,16 +128,25 @@ func (s *Scanner) Scan(rootPath string) (*ScanResult, error) {
 		IgnoredFiles: []IgnoredFile{},
 	}
 
-	// Check if it's a git repository
-	if !isGitRepo(absPath) {
-		return result, nil
+	if err := s.compileFilter(); err != nil {
+		return nil, err
 	}
 
-	// Get list of ignored files using git
-	ignoredPaths, err := getGitIgnoredFiles(absPath)
+	if s.Exposed {
+		return result, s.scanTracked(result, absPath)
+	}
+	if s.History {
+		return result, s.scanHistory(result, absPath)
+	}
+
+	// Get list of ignored files; nothing to do outside a git repository
+	ignoredPaths, isRepo, err := s.listIgnored(absPath)
 	if err != nil {
 		return nil, err
 	}
+	if !isRepo {
+		return result, nil
+	}
 
 	for _, path := range ignoredPaths {
 		// Skip dependency directories if ExcludeDeps is true
@@ -77,26 +161,199 @@ func (s *Scanner) Scan(rootPath string) (*ScanResult, error) {
 		}
 
 		if info.IsDir() {
-			continue // skip directories
+			// git collapses fully ignored directories into a single entry,
+			// so walk them to find the files inside
+			s.scanIgnoredDir(result, absPath, path)
+			continue
+		}
+
+		s.addFile(result, absPath, path, info)
+	}
+
+	return result, nil
+}
+
+// scanTracked adds tracked files that are classified as secrets
+func (s *Scanner) scanTracked(result *ScanResult, absPath string) error {
+	trackedPaths, isRepo, err := s.listTracked(absPath)
+	if err != nil || !isRepo {
+		return err
+	}
+
+	for _, path := range trackedPaths {
+		if s.ExcludeDeps && isInDepsDir(path) {
+			continue
+		}
+
+		// Deleted files and submodules are not reported
+		info, err := os.Lstat(filepath.Join(absPath, path))
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+
+		s.addFile(result, absPath, path, info)
+	}
+
+	return nil
+}
+
+// scanIgnoredDir walks an ignored directory and adds every file inside it
+func (s *Scanner) scanIgnoredDir(result *ScanResult, rootPath, dirPath string) {
+	filepath.WalkDir(filepath.Join(rootPath, dirPath), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil // skip unreadable entries
 		}
 
-		file := IgnoredFile{
-			Path:     path,
-			Size:     info.Size(),
-			Category: categorizeFile(path),
+		relPath, err := filepath.Rel(rootPath, path)
+		if err != nil {
+			return nil
 		}
-		file.IsSecret = isSecretFile(path, file.Category)
+		relPath = filepath.ToSlash(relPath)
 
-		if s.ShowAll || file.IsSecret {
-			result.IgnoredFiles = append(result.IgnoredFiles, file)
-			result.TotalSize += file.Size
-			if file.IsSecret {
-				result.SecretCount++
+		if d.IsDir() {
+			// Nested repositories and submodules are scanned on their own
+			if _, err := os.Lstat(filepath.Join(path, ".git")); err == nil {
+				return filepath.SkipDir
 			}
+			if s.ExcludeDeps && relPath != dirPath && isInDepsDir(relPath+"/") {
+				return filepath.SkipDir
+			}
+			return nil
 		}
+
+		// Symlinks to files are kept so export can restore them as links
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() {
+			return nil // skip dangling links, sockets, etc.
+		}
+
+		s.addFile(result, rootPath, relPath, info)
+		return nil
+	})
+}
+
+// addFile classifies a file and adds it to the result if it should be shown
+func (s *Scanner) addFile(result *ScanResult, rootPath, path string, info os.FileInfo) {
+	file := IgnoredFile{
+		Path: path,
+		Size: info.Size(),
+	}
+	file.Category, file.IsSecret = s.Rules.Classify(path)
+	if !s.selects(path, file.Category, file.Size) {
+		return
 	}
 
-	return result, nil
+	if s.InspectContent {
+		file.Findings = s.inspectContent(filepath.Join(rootPath, path))
+		if s.Exposed {
+			// Entropy alone is too noisy among tracked files, e.g. the
+			// integrity hashes of package-lock.json
+			file.Findings = credentialFindings(file.Findings)
+		}
+		if len(file.Findings) > 0 {
+			file.IsSecret = true
+		}
+	}
+	if s.Exposed && isSourceFile(path) && len(file.Findings) == 0 {
+		// Source files named like keys (keys.go, tokens.ts) hold code;
+		// only a known credential format makes them secrets
+		file.IsSecret = false
+	}
+	file.Confidence = s.confidence(file)
+
+	if s.Exposed {
+		if !file.IsSecret {
+			return // only secrets are worth reporting among tracked files
+		}
+		file.Exposed = true
+		file.GitignoreLine = gitignoreLine(path)
+		file.FixCommand = "git rm --cached -- " + shellQuote(path)
+	}
+
+	if s.ShowAll || file.IsSecret {
+		result.IgnoredFiles = append(result.IgnoredFiles, file)
+		result.TotalSize += file.Size
+		if file.IsSecret {
+			result.SecretCount++
+		}
+	}
+}
+
+// credentialFindings drops entropy findings, keeping known credential
+// formats
+func credentialFindings(findings []Finding) []Finding {
+	var kept []Finding
+	for _, f := range findings {
+		if f.RuleID != RuleHighEntropyBase64 && f.RuleID != RuleHighEntropyHex {
+			kept = append(kept, f)
+		}
+	}
+	return kept
+}
+
+// sourceExtensions are the file types treated as code by exposed scans
+var sourceExtensions = map[string]bool{
+	".go": true, ".js": true, ".jsx": true, ".mjs": true, ".cjs": true, ".ts": true, ".tsx": true,
+	".py": true, ".rb": true, ".php": true, ".java": true, ".kt": true, ".scala": true, ".swift": true,
+	".rs": true, ".c": true, ".h": true, ".cc": true, ".cpp": true, ".hpp": true, ".cs": true,
+	".m": true, ".mm": true, ".sh": true, ".bash": true, ".zsh": true, ".ps1": true, ".lua": true,
+	".dart": true, ".ex": true, ".exs": true, ".erl": true, ".clj": true, ".hs": true, ".ml": true,
+	".vue": true, ".svelte": true,
+}
+
+func isSourceFile(path string) bool {
+	return sourceExtensions[strings.ToLower(filepath.Ext(path))]
+}
+
+// resolveBackend picks git when installed for the auto backend
+func (s *Scanner) resolveBackend() Backend {
+	if s.Backend != "" && s.Backend != BackendAuto {
+		return s.Backend
+	}
+	if _, err := exec.LookPath("git"); err == nil {
+		return BackendGit
+	}
+	return BackendNative
+}
+
+// listTracked returns the tracked paths reported by the selected backend
+func (s *Scanner) listTracked(absPath string) ([]string, bool, error) {
+	switch backend := s.resolveBackend(); backend {
+	case BackendGit:
+		if !isGitRepo(absPath) {
+			return nil, false, nil
+		}
+		paths, err := getGitTrackedFiles(absPath)
+		return paths, true, err
+	case BackendNative:
+		if _, ok := findRepoRoot(absPath); !ok {
+			return nil, false, nil
+		}
+		paths, err := getNativeTrackedFiles(absPath)
+		return paths, true, err
+	default:
+		return nil, false, fmt.Errorf("unknown backend: %s", backend)
+	}
+}
+
+// listIgnored returns the ignored paths reported by the selected backend
+func (s *Scanner) listIgnored(absPath string) ([]string, bool, error) {
+	switch backend := s.resolveBackend(); backend {
+	case BackendGit:
+		if !isGitRepo(absPath) {
+			return nil, false, nil
+		}
+		paths, err := getGitIgnoredFiles(absPath)
+		return paths, true, err
+	case BackendNative:
+		if _, ok := findRepoRoot(absPath); !ok {
+			return nil, false, nil
+		}
+		paths, err := getNativeIgnoredFiles(absPath)
+		return paths, true, err
+	default:
+		return nil, false, fmt.Errorf("unknown backend: %s", backend)
+	}
 }
 
 // isGitRepo checks if the path is inside a git repository
@@ -106,10 +363,11 @@ func isGitRepo(path string) bool {
 	return cmd.Run() == nil
 }
 
-// getGitIgnoredFiles returns a list of files ignored by .gitignore
+// getGitIgnoredFiles returns a list of files ignored by .gitignore.
+// Fully ignored directories are reported once, without a trailing slash.
 func getGitIgnoredFiles(repoPath string) ([]string, error) {
-	// Use git status to find ignored files
-	cmd := exec.Command("git", "status", "--ignored", "--porcelain")
+	// Use git status to find ignored files; -z avoids quoting of unusual paths
+	cmd := exec.Command("git", "status", "--ignored", "--porcelain", "-z")
 	cmd.Dir = repoPath
 	output, err := cmd.Output()
 	if err != nil {
@@ -117,121 +375,114 @@ func getGitIgnoredFiles(repoPath string) ([]string, error) {
 	}
 
 	var ignored []string
-	scanner := bufio.NewScanner(strings.NewReader(string(output)))
-	for scanner.Scan() {
-		line := scanner.Text()
+	for _, entry := range strings.Split(string(output), "\x00") {
 		// Ignored files start with "!! "
-		if strings.HasPrefix(line, "!! ") {
-			path := strings.TrimPrefix(line, "!! ")
+		if strings.HasPrefix(entry, "!! ") {
+			path := strings.TrimPrefix(entry, "!! ")
 			// Remove trailing slash for directories
 			path = strings.TrimSuffix(path, "/")
 			ignored = append(ignored, path)
 		}
 	}
 
-	return ignored, scanner.Err()
+	return ignored, nil
 }
 
-// categorizeFile determines the category of a file
-func categorizeFile(path string) string {
-	name := strings.ToLower(filepath.Base(path))
-	ext := strings.ToLower(filepath.Ext(path))
-
-	// Environment files
-	if strings.HasPrefix(name, ".env") || strings.HasPrefix(name, "env.") {
-		return "env"
+// confidence scores how likely a file holds secrets by combining the
+// independent signals (name, content rules, entropy) as a noisy-OR
+func (s *Scanner) confidence(file IgnoredFile) float64 {
+	if !file.IsSecret {
+		return 0
 	}
 
-	// Key/credential files
-	keyPatterns := []string{
-		"key", "secret", "credential", "token", "password",
-		"private", "pem", "p12", "pfx", "keystore",
+	// Strongest score per signal, so many matching lines don't saturate it
+	signals := make(map[string]float64)
+	if _, nameSecret := s.Rules.Classify(file.Path); nameSecret {
+		signals["name"] = 0.5
 	}
-	for _, pattern := range keyPatterns {
-		if strings.Contains(name, pattern) {
-			return "key"
+	for _, f := range file.Findings {
+		score := 0.9
+		if f.RuleID == RuleHighEntropyBase64 || f.RuleID == RuleHighEntropyHex {
+			// 0.5 at the threshold, rising to 0.85 one bit above it
+			over := f.Entropy - s.Entropy.threshold(f.RuleID)
+			score = 0.5 + 0.35*math.Min(1, math.Max(0, over))
 		}
-	}
-	if ext == ".pem" || ext == ".key" || ext == ".p12" || ext == ".pfx" {
-		return "key"
-	}
-
-	// Config files
-	if ext == ".json" || ext == ".yaml" || ext == ".yml" || ext == ".toml" || ext == ".ini" {
-		if strings.Contains(name, "config") || strings.Contains(name, "setting") {
-			return "config"
+		if score > signals[f.RuleID] {
+			signals[f.RuleID] = score
 		}
 	}
 
-	// Build outputs
-	buildDirs := []string{"node_modules", "dist", "build", ".next", "__pycache__", "target", "bin", "obj"}
-	for _, dir := range buildDirs {
-		if strings.Contains(path, dir+"/") || strings.HasPrefix(path, dir+"/") || path == dir {
-			return "build"
-		}
+	miss := 1.0
+	for _, score := range signals {
+		miss *= 1 - score
 	}
+	return 1 - miss
+}
 
-	// Cache
-	if strings.Contains(path, "cache") || strings.HasPrefix(name, ".") && strings.Contains(name, "cache") {
-		return "cache"
+// getGitTrackedFiles returns the files tracked below repoPath, relative to it
+func getGitTrackedFiles(repoPath string) ([]string, error) {
+	cmd := exec.Command("git", "ls-files", "-z")
+	cmd.Dir = repoPath
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, err
 	}
 
-	// IDE/Editor
-	ideDirs := []string{".idea", ".vscode", ".vs"}
-	for _, dir := range ideDirs {
-		if strings.HasPrefix(path, dir+"/") || path == dir {
-			return "ide"
+	var tracked []string
+	for _, path := range strings.Split(string(output), "\x00") {
+		if path != "" {
+			tracked = append(tracked, path)
 		}
 	}
 
-	return "other"
+	return tracked, nil
 }
 
-// isSecretFile determines if a file likely contains secrets
-func isSecretFile(path string, category string) bool {
-	// env and key categories are always considered secrets
-	if category == "env" || category == "key" {
-		return true
-	}
-
-	name := strings.ToLower(filepath.Base(path))
-
-	// Additional secret patterns
-	secretPatterns := []string{
-		"secret", "credential", "password", "token",
-		"auth", "api_key", "apikey", ".npmrc", ".netrc",
-		"id_rsa", "id_dsa", "id_ecdsa", "id_ed25519",
-	}
-	for _, pattern := range secretPatterns {
-		if strings.Contains(name, pattern) {
-			return true
+// gitignoreLine returns a .gitignore line matching exactly one path
+func gitignoreLine(path string) string {
+	var b strings.Builder
+	b.WriteString("/")
+	for _, r := range path {
+		if strings.ContainsRune(`\*?[!# `, r) {
+			b.WriteRune('\\')
 		}
+		b.WriteRune(r)
 	}
+	return b.String()
+}
 
-	return false
+// shellQuote quotes a path for POSIX shells when needed
+func shellQuote(s string) string {
+	if s != "" && strings.IndexFunc(s, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("._-/+@", r))
+	}) < 0 {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
 }
 
-// cachedDepsPatterns caches patterns loaded from config
-var cachedDepsPatterns []string
+// cachedDepsPatterns caches patterns loaded from config; depsOnce guards
+// it so concurrent scans load the config only once
+var (
+	cachedDepsPatterns []string
+	depsOnce           sync.Once
+)
 
 // loadDepsPatterns loads patterns from config or uses defaults
 func loadDepsPatterns() []string {
-	if cachedDepsPatterns != nil {
-		return cachedDepsPatterns
-	}
-
-	// Try to load from config
-	cfg, err := config.LoadPatterns()
-	if err == nil && cfg != nil {
-		patterns := cfg.GetAllDepsDirs()
-		if len(patterns) > 0 {
-			cachedDepsPatterns = patterns
-			return cachedDepsPatterns
+	depsOnce.Do(func() {
+		// Try to load from config
+		cfg, err := config.LoadPatterns()
+		if err == nil && cfg != nil {
+			if patterns := cfg.GetAllDepsDirs(); len(patterns) > 0 {
+				cachedDepsPatterns = patterns
+				return
+			}
 		}
-	}
 
-	// Fall back to defaults
-	cachedDepsPatterns = defaultDepsDirs()
+		// Fall back to defaults
+		cachedDepsPatterns = defaultDepsDirs()
+	})
 	return cachedDepsPatterns
 }
 
@@ -301,9 +552,9 @@ func isInDepsDir(path string) bool {
 
 		// Check if path starts with or contains the pattern
 		if strings.HasPrefix(path, pattern+"/") ||
-		   strings.HasPrefix(path, pattern) ||
-		   strings.Contains(path, "/"+pattern+"/") ||
-		   strings.Contains(path, "/"+pattern) {
+			strings.HasPrefix(path, pattern) ||
+			strings.Contains(path, "/"+pattern+"/") ||
+			strings.Contains(path, "/"+pattern) {
 			return true
 		}
 	}